@@ -175,14 +175,21 @@ func NewReplyError(req *http.Request, resp *http.Response, reply *v1.Reply) erro
 // NewInfraError returns an error that originates from the infrastructure, and does not originate from
 // the client or service implementation.
 func NewInfraError(req *http.Request, resp *http.Response, body []byte) error {
+	details := map[string]string{
+		DetailsHttpCode:   strconv.Itoa(resp.StatusCode),
+		DetailsHttpBody:   string(body),
+		DetailsHttpUrl:    req.URL.String(),
+		DetailsHttpStatus: resp.Status,
+		DetailsHttpMethod: req.Method,
+	}
+
+	// Proxies and load balancers may include Retry-After on 429 and 503 responses
+	if ra := resp.Header.Get("Retry-After"); ra != "" {
+		details[DetailsHttpRetryAfter] = ra
+	}
+
 	return &ClientError{
-		details: map[string]string{
-			DetailsHttpCode:   strconv.Itoa(resp.StatusCode),
-			DetailsHttpBody:   string(body),
-			DetailsHttpUrl:    req.URL.String(),
-			DetailsHttpStatus: resp.Status,
-			DetailsHttpMethod: req.Method,
-		},
+		details:      details,
 		msg:          string(body),
 		code:         strconv.Itoa(resp.StatusCode),
 		httpCode:     resp.StatusCode,
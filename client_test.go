@@ -195,6 +195,27 @@ func TestClientErrorIsInfraError(t *testing.T) {
 	assert.Equal(t, http.StatusBadGateway, ce.HTTPCode())
 }
 
+func TestInfraErrorRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("service unavailable"))
+	}))
+	defer server.Close()
+
+	c := &duh.Client{Client: &http.Client{}}
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/v1/test", nil)
+	require.NoError(t, err)
+
+	err = c.Do(req, nil)
+	require.Error(t, err)
+
+	var ce *duh.ClientError
+	require.True(t, errors.As(err, &ce))
+	assert.True(t, ce.IsInfraError())
+	assert.Equal(t, "3", ce.Details()[duh.DetailsHttpRetryAfter])
+}
+
 func TestErrorInterface(t *testing.T) {
 	// Service error: Code() returns string representation of HTTP code
 	err := duh.NewServiceError(duh.CodeBadRequest, "invalid input", nil, nil)
@@ -16,14 +16,19 @@ package duh
 
 import (
 	"net/http"
+	"slices"
+	"time"
 
 	"github.com/duh-rpc/duh.go/v2/retry"
 )
 
 // RetryableCodes are service response codes that indicate a transient failure.
+// This slice is shared by every user of this package and must not be modified; use
+// PolicyOnRetryable() to build a policy which retries additional codes.
 var RetryableCodes = []int{CodeTooManyRequests, CodeRetryRequest, CodeInternalError}
 
-// RetryableInfraCodes are infrastructure response codes worth retrying.
+// RetryableInfraCodes are infrastructure response codes worth retrying. Like RetryableCodes,
+// this slice must not be modified.
 // NOTE: 404 is intentionally included. An infra 404 means the service is not
 // routable (e.g., no backends registered) -- this is transient and worth retrying.
 // A service 404 (with Reply body) means "resource not found" and is NOT in
@@ -39,3 +44,83 @@ var OnRetryable = retry.Policy{
 	OnInfraCodes: RetryableInfraCodes,
 	Attempts:     0,
 }
+
+// PolicyOnRetryable returns a new policy equivalent to OnRetryable which also retries the
+// provided service codes. The returned policy holds private copies of the code slices, so
+// callers may modify them without affecting OnRetryable or any other policy.
+func PolicyOnRetryable(codes ...int) retry.Policy {
+	p := OnRetryable
+	p.OnCodes = slices.Concat(RetryableCodes, codes)
+	p.OnInfraCodes = slices.Clone(RetryableInfraCodes)
+	return p
+}
+
+// PolicyAWSStyle returns a policy modeled on the standard retry mode of the AWS SDKs. It makes
+// at most 3 attempts with a full jitter back off starting at 100ms and capped at 20s. Pick this
+// for interactive requests where a caller is waiting and a fast failure is preferred over a
+// long retry.
+//
+//	Min: 100ms, Max: 20s, Factor: 2, Mode: JitterFull, Attempts: 3
+func PolicyAWSStyle() retry.Policy {
+	return presetPolicy(retry.BackOff{
+		Min:    100 * time.Millisecond,
+		Max:    20 * time.Second,
+		Factor: 2,
+		Mode:   retry.JitterFull,
+	}, 3)
+}
+
+// PolicyGoogleStyle returns a policy using the defaults of the ExponentialBackOff from the
+// Google HTTP client for Java. It starts at 500ms and grows by 1.5 with 50% symmetric jitter
+// up to 60s, retrying until the context is cancelled. Pick this for background work which
+// should keep trying through a prolonged outage; bound it with a context deadline.
+//
+//	Min: 500ms, Max: 60s, Factor: 1.5, Jitter: 0.5, Mode: JitterSymmetric, Attempts: 0
+func PolicyGoogleStyle() retry.Policy {
+	return presetPolicy(retry.BackOff{
+		Min:    500 * time.Millisecond,
+		Max:    60 * time.Second,
+		Factor: 1.5,
+		Jitter: 0.5,
+		Mode:   retry.JitterSymmetric,
+	}, 0)
+}
+
+// PolicyAggressive returns a policy which retries quickly and often, starting at 10ms and
+// capped at 1s for at most 10 attempts. Pick this for low latency calls to a local or highly
+// available dependency where failures are brief. Avoid it against a struggling service, where
+// the extra load will slow recovery; pair it with a Budget when in doubt.
+//
+//	Min: 10ms, Max: 1s, Factor: 2, Jitter: 0.2, Attempts: 10
+func PolicyAggressive() retry.Policy {
+	return presetPolicy(retry.BackOff{
+		Min:    10 * time.Millisecond,
+		Max:    time.Second,
+		Factor: 2,
+		Jitter: 0.2,
+	}, 10)
+}
+
+// PolicyConservative returns a policy which backs off quickly to give a struggling service
+// room to recover, starting at 1s, tripling each attempt and capped at 2m for at most 5
+// attempts. Pick this for calls to shared or rate limited services where being a good
+// citizen matters more than latency.
+//
+//	Min: 1s, Max: 2m, Factor: 3, Mode: JitterFull, Attempts: 5
+func PolicyConservative() retry.Policy {
+	return presetPolicy(retry.BackOff{
+		Min:    time.Second,
+		Max:    2 * time.Minute,
+		Factor: 3,
+		Mode:   retry.JitterFull,
+	}, 5)
+}
+
+// presetPolicy returns a policy which retries the same codes as OnRetryable using the
+// provided back off and attempts
+func presetPolicy(b retry.BackOff, attempts int) retry.Policy {
+	p := PolicyOnRetryable()
+	p.Interval = b
+	p.Attempts = attempts
+	return p
+}
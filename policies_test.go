@@ -0,0 +1,74 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package duh_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/duh-rpc/duh.go/v2"
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyOnRetryable(t *testing.T) {
+	a := duh.PolicyOnRetryable(duh.CodeConflict)
+	b := duh.PolicyOnRetryable()
+
+	assert.Equal(t, []int{duh.CodeTooManyRequests, duh.CodeRetryRequest, duh.CodeInternalError,
+		duh.CodeConflict}, a.OnCodes)
+	assert.Equal(t, duh.RetryableCodes, b.OnCodes)
+	assert.Equal(t, duh.RetryableInfraCodes, a.OnInfraCodes)
+	assert.Equal(t, duh.OnRetryable.Interval, a.Interval)
+
+	// Modifying one policy must not affect another or the package level codes
+	a.OnCodes[0] = duh.CodeBadRequest
+	a.OnInfraCodes[0] = http.StatusTeapot
+	assert.Equal(t, duh.CodeTooManyRequests, b.OnCodes[0])
+	assert.Equal(t, duh.CodeTooManyRequests, duh.RetryableCodes[0])
+	assert.Equal(t, duh.CodeNotFound, b.OnInfraCodes[0])
+	assert.Equal(t, duh.CodeNotFound, duh.RetryableInfraCodes[0])
+}
+
+func TestPolicyPresets(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		policy retry.Policy
+	}{
+		{name: "AWSStyle", policy: duh.PolicyAWSStyle()},
+		{name: "GoogleStyle", policy: duh.PolicyGoogleStyle()},
+		{name: "Aggressive", policy: duh.PolicyAggressive()},
+		{name: "Conservative", policy: duh.PolicyConservative()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.policy.Validate())
+			assert.Equal(t, duh.RetryableCodes, tt.policy.OnCodes)
+			assert.Equal(t, duh.RetryableInfraCodes, tt.policy.OnInfraCodes)
+
+			// Each call returns private code slices
+			tt.policy.OnCodes[0] = duh.CodeBadRequest
+			assert.Equal(t, duh.CodeTooManyRequests, duh.RetryableCodes[0])
+		})
+	}
+}
+
+func TestPolicyString(t *testing.T) {
+	// The description of the default policy is logged by clients, and should remain stable
+	assert.Equal(t, "backoff min=500ms max=5s factor=2 jitter=0.2 attempts=∞ "+
+		"codes=[429,454,500] infra_codes=[404,502,503,504]", duh.OnRetryable.String())
+	assert.Equal(t, "backoff min=100ms max=20s factor=2 mode=full attempts=3 "+
+		"codes=[429,454,500] infra_codes=[404,502,503,504]", duh.PolicyAWSStyle().String())
+}
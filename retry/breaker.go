@@ -0,0 +1,189 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by On() when Policy.Breaker is open and the operation was not called
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// DefaultBreakerMinRequests is the number of calls within a window a Breaker requires before
+// it may open, when Breaker.MinRequests is zero
+const DefaultBreakerMinRequests = 5
+
+// CircuitOpenError is returned by On() when Policy.Breaker opens after at least one attempt
+// failed. It unwraps to both ErrCircuitOpen and the last operation error, such that
+// errors.Is(err, ErrCircuitOpen) still holds while the failure which caused the retry is not lost.
+type CircuitOpenError struct {
+	// Last is the error returned by the final attempt, or all the errors if
+	// Policy.CollectErrors is true
+	Last error
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s; last error: %s", ErrCircuitOpen, e.Last)
+}
+
+func (e *CircuitOpenError) Unwrap() []error {
+	return []error{ErrCircuitOpen, e.Last}
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// Breaker is a circuit breaker which opens when the ratio of failures to total calls within a
+// window exceeds a threshold, once the window has seen at least MinRequests calls. Once open,
+// the breaker rejects all calls until the cooldown has elapsed, at which point it becomes
+// half-open and allows a single probe call at a time. A successful probe closes the breaker,
+// a failed probe opens it again for another cooldown.
+//
+//	policy := retry.Policy{
+//		Interval: retry.DefaultBackOff,
+//		Breaker:  retry.NewBreaker(0.5, 10*time.Second, 30*time.Second),
+//	}
+//
+// A Breaker is safe for concurrent use and is intended to be shared by all callers of a service.
+type Breaker struct {
+	// Clock is the source of time used by the breaker, defaults to RealClock
+	Clock Clock
+	// MinRequests is the number of calls within a window required before the breaker may
+	// open, defaults to DefaultBreakerMinRequests. Without a minimum, the first call of a
+	// window failing is a ratio of 1 and a single transient error would open the breaker.
+	MinRequests int
+
+	mu          sync.Mutex
+	threshold   float64
+	window      time.Duration
+	cooldown    time.Duration
+	state       breakerState
+	windowStart time.Time
+	openedAt    time.Time
+	successes   int
+	failures    int
+	probing     bool
+}
+
+// NewBreaker returns a Breaker which opens when the ratio of failures to total calls within
+// the window exceeds the threshold, and stays open for the cooldown.
+func NewBreaker(threshold float64, window, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow returns true if a call should be made
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// Success records a successful call
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.close()
+		return
+	}
+	b.rotate()
+	b.successes++
+}
+
+// Failure records a failed call
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerHalfOpen:
+		b.open()
+		return
+	case breakerOpen:
+		return
+	}
+
+	b.rotate()
+	b.failures++
+	total := b.successes + b.failures
+	if total >= b.minRequests() && float64(b.failures)/float64(total) > b.threshold {
+		b.open()
+	}
+}
+
+func (b *Breaker) minRequests() int {
+	if b.MinRequests != 0 {
+		return b.MinRequests
+	}
+	return DefaultBreakerMinRequests
+}
+
+// rotate starts a new window of counts if the current window has elapsed
+func (b *Breaker) rotate() {
+	now := b.now()
+	if now.Sub(b.windowStart) >= b.window {
+		b.windowStart = now
+		b.successes, b.failures = 0, 0
+	}
+}
+
+func (b *Breaker) open() {
+	b.state = breakerOpen
+	b.openedAt = b.now()
+	b.probing = false
+}
+
+func (b *Breaker) close() {
+	b.state = breakerClosed
+	b.windowStart = b.now()
+	b.successes, b.failures = 0, 0
+	b.probing = false
+}
+
+func (b *Breaker) now() time.Time {
+	if b.Clock != nil {
+		return b.Clock.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	b := retry.NewBreaker(0.5, 10*time.Second, 30*time.Second)
+	b.Clock = clock
+	b.MinRequests = 1
+
+	// Stays closed while the failure ratio is at or below the threshold
+	b.Success()
+	b.Failure()
+	assert.True(t, b.Allow())
+
+	// Opens once the ratio exceeds the threshold
+	b.Failure()
+	assert.False(t, b.Allow())
+
+	// Becomes half-open after the cooldown and allows a single probe
+	clock.now = clock.now.Add(30 * time.Second)
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+
+	// A failed probe opens the breaker again
+	b.Failure()
+	assert.False(t, b.Allow())
+
+	// A successful probe closes the breaker
+	clock.now = clock.now.Add(30 * time.Second)
+	assert.True(t, b.Allow())
+	b.Success()
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+
+	t.Run("MinRequests", func(t *testing.T) {
+		b := retry.NewBreaker(0.5, 10*time.Second, 30*time.Second)
+		b.Clock = clock
+		// A single transient failure does not open the breaker
+		b.Failure()
+		assert.True(t, b.Allow())
+		for i := 1; i < retry.DefaultBreakerMinRequests-1; i++ {
+			b.Failure()
+		}
+		assert.True(t, b.Allow())
+		// Opens once the window has seen enough calls
+		b.Failure()
+		assert.False(t, b.Allow())
+	})
+
+	t.Run("WindowRotates", func(t *testing.T) {
+		b := retry.NewBreaker(0.5, 10*time.Second, 30*time.Second)
+		b.Clock = clock
+		b.Success()
+		b.Failure()
+		// Failures from a previous window are forgotten
+		clock.now = clock.now.Add(10 * time.Second)
+		b.Success()
+		b.Success()
+		b.Failure()
+		assert.True(t, b.Allow())
+	})
+}
+
+func TestPolicyBreaker(t *testing.T) {
+	errFail := errors.New("fail")
+	breaker := retry.NewBreaker(0.5, time.Minute, time.Minute)
+	policy := retry.Policy{
+		Interval: retry.Sleep(time.Millisecond),
+		Breaker:  breaker,
+		Attempts: 10,
+	}
+
+	var count int
+	err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		count++
+		return errFail
+	})
+	// A single failure does not open the breaker, it opens once the window has seen enough
+	// calls, and the remaining retries are short-circuited
+	require.ErrorIs(t, err, retry.ErrCircuitOpen)
+	assert.Equal(t, retry.DefaultBreakerMinRequests, count)
+	// The error from the last attempt is not lost
+	require.ErrorIs(t, err, errFail)
+	var coe *retry.CircuitOpenError
+	require.ErrorAs(t, err, &coe)
+	assert.Equal(t, errFail, coe.Last)
+	assert.Equal(t, "circuit breaker is open; last error: fail", err.Error())
+
+	// While open, the operation is never called
+	count = 0
+	err = retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		count++
+		return nil
+	})
+	require.ErrorIs(t, err, retry.ErrCircuitOpen)
+	assert.Equal(t, 0, count)
+}
@@ -0,0 +1,499 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExhausted is returned by On() when the Policy.Budget did not recover in time, or
+// when the budget is over and Policy.BudgetOver is BudgetFailFast
+var ErrBudgetExhausted = errors.New("retry budget exhausted")
+
+// BudgetExhaustedError is returned by On() when Policy.Budget stops the retry loop after at
+// least one attempt failed. It unwraps to both ErrBudgetExhausted and the last operation error,
+// such that errors.Is(err, ErrBudgetExhausted) still holds while the failure which caused the
+// retry is not lost.
+type BudgetExhaustedError struct {
+	// Last is the error returned by the final attempt, or all the errors if
+	// Policy.CollectErrors is true
+	Last error
+}
+
+func (e *BudgetExhaustedError) Error() string {
+	return fmt.Sprintf("%s; last error: %s", ErrBudgetExhausted, e.Last)
+}
+
+func (e *BudgetExhaustedError) Unwrap() []error {
+	return []error{ErrBudgetExhausted, e.Last}
+}
+
+// BudgetOverBehavior selects what On() does when Policy.Budget is over
+type BudgetOverBehavior int
+
+const (
+	// BudgetBlock sleeps according to the Interval until the budget recovers, the
+	// Policy.BudgetWaitTimeout elapses or the context is cancelled. This is the default.
+	BudgetBlock BudgetOverBehavior = iota
+	// BudgetFailFast returns ErrBudgetExhausted immediately, such that the caller can shed
+	// load or return a cached response instead of waiting for the budget to recover.
+	BudgetFailFast
+)
+
+// Budget limits the number of retries On() will perform across all callers which share
+// the budget. This prevents a fleet of clients from overwhelming a struggling service
+// with retries.
+type Budget interface {
+	// IsOver returns true if the budget is exhausted and retries should not be attempted
+	IsOver(now time.Time) bool
+	// Success records a successful call to the operation
+	Success(now time.Time, hits int)
+	// Failure records a failed call to the operation
+	Failure(now time.Time, hits int)
+	// Reset clears all recorded history, returning the budget to its initial state
+	Reset()
+}
+
+// BudgetRecoverer is implemented by a Budget which can estimate how long until it recovers,
+// such that callers can surface a meaningful "try again in N seconds" instead of spinning.
+type BudgetRecoverer interface {
+	// RecoversIn returns an estimate of how long until IsOver() returns false, assuming no
+	// new failures are recorded. Returns 0 if the budget is not over.
+	RecoversIn(now time.Time) time.Duration
+}
+
+// WeightedBudget is implemented by a Budget which can record a failure with a fractional
+// weight, such that a soft failure like a 429 can count less against the budget than a 500.
+type WeightedBudget interface {
+	// FailureWeighted records a failed call to the operation which counts as 'weight' hits
+	FailureWeighted(now time.Time, weight float64)
+}
+
+// failureWeighted records a weighted failure to a Budget wrapped by another budget. If the
+// wrapped budget does not implement WeightedBudget, the weight is rounded up to whole hits.
+func failureWeighted(b Budget, now time.Time, weight float64) {
+	if wb, ok := b.(WeightedBudget); ok {
+		wb.FailureWeighted(now, weight)
+		return
+	}
+	b.Failure(now, int(math.Ceil(weight)))
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucketBudget returns a Budget which allows at most 'burst' retries at once, and
+// refills at 'rate' retries per second. Each failure consumes a token and the budget is
+// over when no tokens remain. Successes do not affect the budget.
+//
+//	policy := retry.Policy{
+//		Interval: retry.DefaultBackOff,
+//		Budget:   retry.NewTokenBucketBudget(10, 20),
+//	}
+func NewTokenBucketBudget(rate float64, burst int) Budget {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+func (t *tokenBucket) IsOver(now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refill(now)
+	return t.tokens < 1
+}
+
+// RecoversIn returns the time until a single token has refilled. The budget never recovers
+// if the refill rate is zero, in which case the largest possible duration is returned.
+func (t *tokenBucket) RecoversIn(now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refill(now)
+	if t.tokens >= 1 {
+		return 0
+	}
+	if t.rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(((1 - t.tokens) / t.rate) * float64(time.Second))
+}
+
+func (t *tokenBucket) Success(_ time.Time, _ int) {}
+
+func (t *tokenBucket) Failure(now time.Time, hits int) {
+	t.FailureWeighted(now, float64(hits))
+}
+
+func (t *tokenBucket) FailureWeighted(now time.Time, weight float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refill(now)
+	t.tokens -= weight
+	if t.tokens < 0 {
+		t.tokens = 0
+	}
+}
+
+func (t *tokenBucket) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens = t.burst
+	t.last = time.Time{}
+}
+
+func (t *tokenBucket) refill(now time.Time) {
+	if t.last.IsZero() {
+		t.last = now
+		return
+	}
+	if elapsed := now.Sub(t.last).Seconds(); elapsed > 0 {
+		t.tokens = min(t.burst, t.tokens+(elapsed*t.rate))
+		t.last = now
+	}
+}
+
+// BudgetSnapshot is a consistent view of the state of a ratio based Budget, suitable for
+// exporting as metrics.
+type BudgetSnapshot struct {
+	// Successes is the weight of successes currently counted by the budget, after decay or
+	// expiry from the window
+	Successes float64
+	// Failures is the weight of failures currently counted by the budget
+	Failures float64
+	// Ratio is Failures divided by Successes. It is +Inf when failures are counted without any
+	// successes, and 0 when neither are counted.
+	Ratio float64
+	// Threshold is the ratio above which the budget is over
+	Threshold float64
+	// Floor is the number of failures the budget tolerates regardless of the ratio, see
+	// WithFailureFloor(). The budget is over only when Failures exceeds both the Floor and
+	// the Threshold.
+	Floor float64
+	// Over is the value IsOver() returns at the same instant
+	Over bool
+}
+
+// BudgetSnapshotter is implemented by a Budget which can report its state in a single
+// consistent read, such that monitoring need not make several calls which could race with
+// hits recorded in between. The budgets returned by NewEWMABudget() and
+// NewSlidingLogBudget() implement it.
+type BudgetSnapshotter interface {
+	Snapshot(now time.Time) BudgetSnapshot
+}
+
+// BudgetOption configures a ratio based Budget created by NewEWMABudget() or
+// NewSlidingLogBudget()
+type BudgetOption func(*ratioBudget)
+
+// WithFailureFloor sets the number of failures a budget tolerates before it is over regardless
+// of the ratio, defaults to 1. Without a floor, the very first failure on a cold budget would
+// exceed any ratio of zero successes and block retries before a baseline exists. The floor
+// applies whenever the failures are below it, not only with zero successes, as an EWMA budget
+// which has been idle decays its successes toward zero but never to exactly zero.
+func WithFailureFloor(failures float64) BudgetOption {
+	return func(r *ratioBudget) {
+		r.floor = failures
+	}
+}
+
+// ratioBudget holds the configuration shared by budgets which are over when the ratio of
+// failures to successes exceeds a threshold
+type ratioBudget struct {
+	ratio float64
+	floor float64
+}
+
+func newRatioBudget(ratio float64, opts []BudgetOption) ratioBudget {
+	r := ratioBudget{ratio: ratio, floor: 1}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	return r
+}
+
+// isOver returns true if the failures exceed both the floor and the ratio of successes. With
+// few hits counted the ratio is meaningless, so the failures must also exceed the floor.
+func (r ratioBudget) isOver(failures, successes float64) bool {
+	return failures > r.floor && failures > successes*r.ratio
+}
+
+// snapshot returns the state of a ratio budget with the provided counts
+func (r ratioBudget) snapshot(failures, successes float64) BudgetSnapshot {
+	s := BudgetSnapshot{
+		Successes: successes,
+		Failures:  failures,
+		Threshold: r.ratio,
+		Floor:     r.floor,
+		Over:      r.isOver(failures, successes),
+	}
+	switch {
+	case successes > 0:
+		s.Ratio = failures / successes
+	case failures > 0:
+		s.Ratio = math.Inf(1)
+	}
+	return s
+}
+
+type ewmaBudget struct {
+	ratioBudget
+	mu        sync.Mutex
+	halfLife  time.Duration
+	successes float64
+	failures  float64
+	last      time.Time
+}
+
+// NewEWMABudget returns a Budget which is over when the ratio of failures to successes exceeds
+// 'ratio'. Successes and failures are exponentially decayed such that a hit loses half its
+// weight every 'halfLife'. Unlike a windowed budget, old failures fade out gradually instead
+// of all at once, which avoids oscillating around the ratio as the window moves.
+//
+//	// Over when there is more than 1 failure for every 10 successes, with
+//	// hits from 30 seconds ago counting half as much as hits from now.
+//	budget := retry.NewEWMABudget(0.1, 30*time.Second)
+//
+// The budget is not over until the failures also exceed the floor set by WithFailureFloor().
+// Successes and failures decay at the same rate, so decay alone never changes their ratio; an
+// over budget which records no new hits recovers once its failures decay below the floor. As
+// such a floor of zero means a budget which is over stays over until successes are recorded.
+func NewEWMABudget(ratio float64, halfLife time.Duration, opts ...BudgetOption) Budget {
+	return &ewmaBudget{
+		ratioBudget: newRatioBudget(ratio, opts),
+		halfLife:    halfLife,
+	}
+}
+
+func (e *ewmaBudget) IsOver(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.decay(now)
+	return e.isOver(e.failures, e.successes)
+}
+
+// RecoversIn returns the time until the failures decay below the floor, assuming no new hits
+// are recorded. The budget never recovers without new hits if the floor is zero, in which case
+// the largest possible duration is returned.
+func (e *ewmaBudget) RecoversIn(now time.Time) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.decay(now)
+	if !e.isOver(e.failures, e.successes) {
+		return 0
+	}
+	if e.floor <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(math.Log2(e.failures/e.floor) * float64(e.halfLife))
+}
+
+func (e *ewmaBudget) Snapshot(now time.Time) BudgetSnapshot {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.decay(now)
+	return e.snapshot(e.failures, e.successes)
+}
+
+func (e *ewmaBudget) Success(now time.Time, hits int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.decay(now)
+	e.successes += float64(hits)
+}
+
+func (e *ewmaBudget) Failure(now time.Time, hits int) {
+	e.FailureWeighted(now, float64(hits))
+}
+
+func (e *ewmaBudget) FailureWeighted(now time.Time, weight float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.decay(now)
+	e.failures += weight
+}
+
+func (e *ewmaBudget) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.successes, e.failures = 0, 0
+	e.last = time.Time{}
+}
+
+func (e *ewmaBudget) decay(now time.Time) {
+	if e.last.IsZero() {
+		e.last = now
+		return
+	}
+	if elapsed := now.Sub(e.last); elapsed > 0 {
+		factor := decayFactor(elapsed, e.halfLife)
+		e.successes *= factor
+		e.failures *= factor
+		e.last = now
+	}
+}
+
+// decayFactor returns the weight remaining of a hit after 'elapsed', where a hit loses half
+// its weight every 'halfLife'
+func decayFactor(elapsed, halfLife time.Duration) float64 {
+	return math.Pow(0.5, float64(elapsed)/float64(halfLife))
+}
+
+type logEntry struct {
+	at      time.Time
+	weight  float64
+	failure bool
+}
+
+type slidingLogBudget struct {
+	ratioBudget
+	mu        sync.Mutex
+	window    time.Duration
+	entries   []logEntry
+	successes float64
+	failures  float64
+}
+
+// NewSlidingLogBudget returns a Budget which is over when the ratio of failures to successes
+// recorded within the trailing 'window' exceeds 'ratio'. Unlike NewEWMABudget, which
+// approximates history by decaying a pair of counters, the sliding log stores the time of
+// every call and counts exactly the hits within the window.
+//
+// The exact count comes at the cost of memory which grows with the number of calls in the
+// window, O(calls) instead of the O(1) of the EWMA budget. Prefer it for low volume callers
+// where accuracy matters, and NewEWMABudget for high volume callers.
+//
+//	// Over when there is more than 1 failure for every 10 successes in the last minute
+//	budget := retry.NewSlidingLogBudget(0.1, time.Minute)
+//
+// The budget is not over until the failures in the window also exceed the floor set by
+// WithFailureFloor().
+func NewSlidingLogBudget(ratio float64, window time.Duration, opts ...BudgetOption) Budget {
+	return &slidingLogBudget{
+		ratioBudget: newRatioBudget(ratio, opts),
+		window:      window,
+	}
+}
+
+func (s *slidingLogBudget) IsOver(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+	return s.isOver(s.failures, s.successes)
+}
+
+// RecoversIn returns the time until enough failures fall out of the window for the budget to
+// no longer be over, assuming no new hits are recorded.
+func (s *slidingLogBudget) RecoversIn(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+	successes, failures := s.successes, s.failures
+	for _, e := range s.entries {
+		if !s.isOver(failures, successes) {
+			break
+		}
+		if e.failure {
+			failures -= e.weight
+		} else {
+			successes -= e.weight
+		}
+		if !s.isOver(failures, successes) {
+			return e.at.Add(s.window).Sub(now)
+		}
+	}
+	return 0
+}
+
+func (s *slidingLogBudget) Snapshot(now time.Time) BudgetSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+	return s.snapshot(s.failures, s.successes)
+}
+
+func (s *slidingLogBudget) Success(now time.Time, hits int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+	s.insert(logEntry{at: now, weight: float64(hits)})
+	s.successes += float64(hits)
+}
+
+func (s *slidingLogBudget) Failure(now time.Time, hits int) {
+	s.FailureWeighted(now, float64(hits))
+}
+
+func (s *slidingLogBudget) FailureWeighted(now time.Time, weight float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+	s.insert(logEntry{at: now, weight: weight, failure: true})
+	s.failures += weight
+}
+
+func (s *slidingLogBudget) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+	s.successes, s.failures = 0, 0
+}
+
+// insert adds the entry to the log, keeping the entries sorted by time. Callers read the time
+// before taking the lock, so concurrent callers may record hits out of order; expire() and
+// RecoversIn() rely on the oldest entries being first.
+func (s *slidingLogBudget) insert(e logEntry) {
+	if n := len(s.entries); n == 0 || !e.at.Before(s.entries[n-1].at) {
+		s.entries = append(s.entries, e)
+		return
+	}
+	i, _ := slices.BinarySearchFunc(s.entries, e.at, func(l logEntry, at time.Time) int {
+		if l.at.After(at) {
+			return 1
+		}
+		return -1
+	})
+	s.entries = slices.Insert(s.entries, i, e)
+}
+
+// expire removes the entries which are no longer within the window
+func (s *slidingLogBudget) expire(now time.Time) {
+	var i int
+	for ; i < len(s.entries); i++ {
+		e := s.entries[i]
+		if now.Sub(e.at) < s.window {
+			break
+		}
+		if e.failure {
+			s.failures -= e.weight
+		} else {
+			s.successes -= e.weight
+		}
+	}
+	if i > 0 {
+		s.entries = slices.Delete(s.entries, 0, i)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type batchedBudget struct {
+	budget    Budget
+	interval  int64
+	successes atomic.Int64
+	failures  atomic.Int64
+	flushed   atomic.Int64
+}
+
+// NewBatchedBudget returns a Budget which accumulates successes and failures in atomic
+// counters and flushes them to the provided Budget at most once per 'interval'. Under very
+// high request rates, recording every call takes the lock of the wrapped budget and can
+// become a point of contention; batching bounds the lock acquisitions for recording to
+// roughly one per interval regardless of the request rate.
+//
+// The trade-off is accuracy, hits recorded since the last flush are not visible to IsOver(),
+// so the budget may take up to 'interval' longer to become over, or to recover. Keep the
+// interval small relative to the window or half-life of the wrapped budget.
+//
+//	budget := retry.NewBatchedBudget(retry.NewEWMABudget(0.1, 30*time.Second), 100*time.Millisecond)
+//
+// The returned budget does not implement WeightedBudget, every failure counts as one hit.
+func NewBatchedBudget(budget Budget, interval time.Duration) Budget {
+	return &batchedBudget{
+		budget:   budget,
+		interval: int64(interval),
+	}
+}
+
+func (b *batchedBudget) IsOver(now time.Time) bool {
+	b.maybeFlush(now)
+	return b.budget.IsOver(now)
+}
+
+func (b *batchedBudget) Success(now time.Time, hits int) {
+	b.successes.Add(int64(hits))
+	b.maybeFlush(now)
+}
+
+func (b *batchedBudget) Failure(now time.Time, hits int) {
+	b.failures.Add(int64(hits))
+	b.maybeFlush(now)
+}
+
+// Reset discards any hits which have not been flushed and resets the wrapped budget
+func (b *batchedBudget) Reset() {
+	b.successes.Store(0)
+	b.failures.Store(0)
+	b.flushed.Store(0)
+	b.budget.Reset()
+}
+
+// maybeFlush flushes the accumulated hits if the interval has elapsed since the last flush.
+// Only the caller which wins the compare and swap flushes, such that concurrent callers do
+// not contend on the wrapped budget.
+func (b *batchedBudget) maybeFlush(now time.Time) {
+	last := b.flushed.Load()
+	if now.UnixNano()-last < b.interval {
+		return
+	}
+	if !b.flushed.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	b.flush(now)
+}
+
+func (b *batchedBudget) flush(now time.Time) {
+	if n := b.successes.Swap(0); n > 0 {
+		b.budget.Success(now, int(n))
+	}
+	if n := b.failures.Swap(0); n > 0 {
+		b.budget.Failure(now, int(n))
+	}
+}
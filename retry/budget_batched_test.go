@@ -0,0 +1,82 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchedBudget(t *testing.T) {
+	start := time.Now()
+	inner := &countingBudget{}
+	b := retry.NewBatchedBudget(inner, 100*time.Millisecond)
+
+	// The first hit is flushed immediately
+	b.Success(start, 1)
+	assert.Equal(t, 1, inner.successes)
+
+	// Hits within the interval accumulate without touching the wrapped budget
+	b.Success(start.Add(10*time.Millisecond), 1)
+	b.Failure(start.Add(20*time.Millisecond), 2)
+	assert.Equal(t, 1, inner.successes)
+	assert.Equal(t, 0, inner.failures)
+
+	// Once the interval elapses IsOver() flushes the accumulated hits
+	assert.False(t, b.IsOver(start.Add(100*time.Millisecond)))
+	assert.Equal(t, 2, inner.successes)
+	assert.Equal(t, 2, inner.failures)
+
+	t.Run("Reset", func(t *testing.T) {
+		b.Failure(start.Add(110*time.Millisecond), 1)
+		b.Reset()
+		assert.Equal(t, 0, inner.failures)
+		// The discarded failure is never flushed
+		b.IsOver(start.Add(time.Second))
+		assert.Equal(t, 0, inner.failures)
+	})
+}
+
+// BenchmarkBudgetRecord compares recording hits directly on a budget, which takes the budget
+// lock on every call, with batching which takes the lock at most once per interval.
+//
+//	go test -run=^$ -bench=BenchmarkBudgetRecord -cpu=1,8 ./retry
+func BenchmarkBudgetRecord(b *testing.B) {
+	for _, bb := range []struct {
+		name   string
+		budget retry.Budget
+	}{
+		{name: "Direct", budget: retry.NewEWMABudget(0.1, 30*time.Second)},
+		{name: "Batched", budget: retry.NewBatchedBudget(retry.NewEWMABudget(0.1, 30*time.Second), 10*time.Millisecond)},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				var i int
+				for pb.Next() {
+					now := time.Now()
+					if i%10 == 0 {
+						bb.budget.Failure(now, 1)
+					} else {
+						bb.budget.Success(now, 1)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
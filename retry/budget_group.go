@@ -0,0 +1,102 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"sync"
+	"time"
+)
+
+// BudgetGroup holds a separate Budget for each key, such that a client which calls many
+// backends can budget retries per backend. A dead backend then exhausts only its own budget
+// instead of throttling retries to healthy backends. Budgets are created on first use by
+// calling the function provided to NewBudgetGroup().
+//
+//	group := retry.NewBudgetGroup(func() retry.Budget {
+//		return retry.NewEWMABudget(0.1, 30*time.Second)
+//	})
+//	policy := retry.Policy{
+//		Interval:    retry.DefaultBackOff,
+//		BudgetGroup: group,
+//	}
+//	err := retry.On(retry.WithBudgetKey(ctx, "backend-a"), policy, op)
+//
+// A BudgetGroup is safe for concurrent use. Call Prune() periodically to release the budgets
+// of backends which are no longer called.
+type BudgetGroup struct {
+	// Clock is the source of time used to track when each budget was last used, defaults
+	// to RealClock
+	Clock Clock
+
+	mu      sync.Mutex
+	create  func() Budget
+	budgets map[string]*groupEntry
+}
+
+type groupEntry struct {
+	budget   Budget
+	lastUsed time.Time
+}
+
+// NewBudgetGroup returns a BudgetGroup which calls 'create' to make the Budget for a new key
+func NewBudgetGroup(create func() Budget) *BudgetGroup {
+	return &BudgetGroup{
+		create:  create,
+		budgets: make(map[string]*groupEntry),
+	}
+}
+
+// Get returns the Budget for the key, creating it if it does not exist
+func (g *BudgetGroup) Get(key string) Budget {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e, ok := g.budgets[key]
+	if !ok {
+		e = &groupEntry{budget: g.create()}
+		g.budgets[key] = e
+	}
+	e.lastUsed = g.now()
+	return e.budget
+}
+
+// Prune removes the budgets which have not been returned by Get() within 'idle', and returns
+// the number of budgets removed. A pruned key starts with a new Budget on its next use.
+func (g *BudgetGroup) Prune(idle time.Duration) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	var removed int
+	for key, e := range g.budgets {
+		if now.Sub(e.lastUsed) >= idle {
+			delete(g.budgets, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Len returns the number of budgets in the group
+func (g *BudgetGroup) Len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.budgets)
+}
+
+func (g *BudgetGroup) now() time.Time {
+	if g.Clock == nil {
+		return RealClock.Now()
+	}
+	return g.Clock.Now()
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudgetGroup(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	group := retry.NewBudgetGroup(func() retry.Budget {
+		return retry.NewTokenBucketBudget(0, 2)
+	})
+	group.Clock = clock
+
+	// The same key returns the same budget
+	a := group.Get("a")
+	assert.Same(t, a, group.Get("a"))
+	assert.Equal(t, 1, group.Len())
+
+	t.Run("Isolation", func(t *testing.T) {
+		policy := retry.Policy{
+			Interval:          retry.Sleep(time.Millisecond),
+			BudgetGroup:       group,
+			BudgetWaitTimeout: time.Millisecond,
+			Clock:             clock,
+		}
+
+		// Backend 'a' exhausts its budget
+		err := retry.On(retry.WithBudgetKey(context.Background(), "a"), policy,
+			func(ctx context.Context, attempt int) error {
+				return errors.New("fail")
+			})
+		require.ErrorIs(t, err, retry.ErrBudgetExhausted)
+		assert.True(t, group.Get("a").IsOver(clock.Now()))
+
+		// Backend 'b' still has a budget and retries
+		err = retry.On(retry.WithBudgetKey(context.Background(), "b"), policy,
+			func(ctx context.Context, attempt int) error {
+				if attempt == 1 {
+					return errors.New("fail")
+				}
+				return nil
+			})
+		require.NoError(t, err)
+		assert.Equal(t, 2, group.Len())
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		clock.now = clock.now.Add(time.Minute)
+		group.Get("c")
+		// Only 'c' has been used within the last minute
+		assert.Equal(t, 2, group.Prune(time.Minute))
+		assert.Equal(t, 1, group.Len())
+
+		// A pruned key starts with a fresh budget
+		assert.NotSame(t, a, group.Get("a"))
+		assert.False(t, group.Get("a").IsOver(clock.Now()))
+	})
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencyObserver is implemented by a Budget which considers the latency of each attempt.
+// On() and Retrier report the latency of every attempt, successful or not, to a Budget
+// which implements it.
+type LatencyObserver interface {
+	// ObserveLatency records the latency of an attempt which ended at 'now'
+	ObserveLatency(now time.Time, latency time.Duration)
+}
+
+type latencyBudget struct {
+	budget    Budget
+	threshold time.Duration
+	halfLife  time.Duration
+	mu        sync.Mutex
+	// sum and count are the exponentially decayed sum of latencies and number of latencies
+	// observed, such that sum / count is the moving average latency. See average().
+	sum   float64
+	count float64
+	last  time.Time
+}
+
+// NewLatencyBudget returns a Budget which is over when the provided Budget is over, or when
+// the moving average latency of attempts exceeds 'threshold'. A backend which responds
+// successfully but so slowly it is effectively down does not trip a budget which only counts
+// failures; retrying it only adds to the brownout. Latencies are exponentially decayed such
+// that a latency loses half its weight every 'halfLife', as with NewEWMABudget().
+//
+//	// Over when more than 1 in 10 calls fail, or calls take longer than 2s on average
+//	budget := retry.NewLatencyBudget(retry.NewEWMABudget(0.1, 30*time.Second), 2*time.Second, 30*time.Second)
+//
+// Latency is reported by On() and Retrier through the LatencyObserver interface, which budget
+// wrappers such as NewBatchedBudget() and NewRampBudget() do not forward; the returned budget
+// should wrap them, not be wrapped by them. The returned budget implements WeightedBudget by
+// forwarding to the wrapped budget, but not BudgetRecoverer or BudgetSnapshotter, as neither
+// would account for the latency.
+//
+// A budget which is over because of latency recovers without new observations, as the
+// average decays toward zero once fewer than a single observation's weight remains.
+func NewLatencyBudget(budget Budget, threshold, halfLife time.Duration) Budget {
+	return &latencyBudget{
+		budget:    budget,
+		threshold: threshold,
+		halfLife:  halfLife,
+	}
+}
+
+func (l *latencyBudget) IsOver(now time.Time) bool {
+	if l.budget.IsOver(now) {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.decay(now)
+	return l.average() > l.threshold
+}
+
+// average returns the moving average latency. Decay alone does not change sum / count, so once
+// the observations have decayed to less than the weight of a single observation the missing
+// weight counts as zero latency. Without this, a budget tripped by slow attempts would stay
+// over while callers are blocked and no new latencies are observed.
+func (l *latencyBudget) average() time.Duration {
+	return time.Duration(l.sum / max(l.count, 1))
+}
+
+func (l *latencyBudget) ObserveLatency(now time.Time, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.decay(now)
+	l.sum += float64(latency)
+	l.count++
+}
+
+func (l *latencyBudget) Success(now time.Time, hits int) {
+	l.budget.Success(now, hits)
+}
+
+func (l *latencyBudget) Failure(now time.Time, hits int) {
+	l.budget.Failure(now, hits)
+}
+
+// FailureWeighted forwards the weighted failure to the wrapped budget
+func (l *latencyBudget) FailureWeighted(now time.Time, weight float64) {
+	failureWeighted(l.budget, now, weight)
+}
+
+// Reset clears the observed latencies and resets the wrapped budget
+func (l *latencyBudget) Reset() {
+	l.mu.Lock()
+	l.sum, l.count = 0, 0
+	l.last = time.Time{}
+	l.mu.Unlock()
+	l.budget.Reset()
+}
+
+func (l *latencyBudget) decay(now time.Time) {
+	if l.last.IsZero() {
+		l.last = now
+		return
+	}
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		factor := decayFactor(elapsed, l.halfLife)
+		l.sum *= factor
+		l.count *= factor
+		l.last = now
+	}
+}
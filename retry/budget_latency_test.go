@@ -0,0 +1,100 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyBudget(t *testing.T) {
+	now := time.Now()
+	inner := &switchBudget{}
+	b := retry.NewLatencyBudget(inner, 500*time.Millisecond, 10*time.Second)
+	observer := b.(retry.LatencyObserver)
+
+	// Every call succeeds, but the latency rises until the average exceeds the threshold
+	latency := 100 * time.Millisecond
+	var over bool
+	for i := 0; i < 100 && !over; i++ {
+		now = now.Add(time.Second)
+		b.Success(now, 1)
+		observer.ObserveLatency(now, latency)
+		over = b.IsOver(now)
+		if !over {
+			latency += 50 * time.Millisecond
+		}
+	}
+	require.True(t, over)
+	// The average lags the most recent latency
+	assert.Greater(t, latency, 500*time.Millisecond)
+
+	// The budget recovers as the latency falls
+	for i := 0; i < 100 && b.IsOver(now); i++ {
+		now = now.Add(time.Second)
+		observer.ObserveLatency(now, 100*time.Millisecond)
+	}
+	assert.False(t, b.IsOver(now))
+
+	// The wrapped budget being over is enough
+	inner.over = true
+	assert.True(t, b.IsOver(now))
+
+	t.Run("RecoversWithoutObservations", func(t *testing.T) {
+		// A caller blocked by the budget makes no attempts, so no new latencies are
+		// observed. The budget must still recover as the slow observations age out.
+		start := time.Now()
+		b := retry.NewLatencyBudget(&switchBudget{}, time.Second, 10*time.Second)
+		for i := 0; i < 10; i++ {
+			b.(retry.LatencyObserver).ObserveLatency(start, 5*time.Second)
+		}
+		assert.True(t, b.IsOver(start))
+		assert.True(t, b.IsOver(start.Add(30*time.Second)))
+		assert.False(t, b.IsOver(start.Add(time.Minute)))
+	})
+
+	t.Run("Weighted", func(t *testing.T) {
+		inner := retry.NewEWMABudget(0.5, time.Hour)
+		b := retry.NewLatencyBudget(inner, time.Second, 10*time.Second)
+		b.(retry.WeightedBudget).FailureWeighted(now, 0.3)
+		assert.Equal(t, 0.3, inner.(retry.BudgetSnapshotter).Snapshot(now).Failures)
+	})
+
+	t.Run("Policy", func(t *testing.T) {
+		clock := &fakeClock{now: time.Now()}
+		b := retry.NewLatencyBudget(&countingBudget{}, time.Second, time.Minute)
+		policy := retry.Policy{
+			Interval:   retry.Sleep(time.Millisecond),
+			Budget:     b,
+			BudgetOver: retry.BudgetFailFast,
+			Clock:      clock,
+		}
+
+		// Each attempt takes 2s to fail, the budget trips after the first attempt
+		var count int
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			count++
+			clock.now = clock.now.Add(2 * time.Second)
+			return retry.Retryable(assert.AnError)
+		})
+		require.ErrorIs(t, err, retry.ErrBudgetExhausted)
+		assert.Equal(t, 1, count)
+	})
+}
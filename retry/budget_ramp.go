@@ -0,0 +1,108 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type rampBudget struct {
+	budget    Budget
+	ramp      time.Duration
+	rand      Random
+	mu        sync.Mutex
+	over      bool
+	recovered time.Time
+}
+
+// NewRampBudget returns a Budget which gradually resumes retries after the provided Budget
+// recovers, instead of resuming at the full rate the moment it is no longer over. For the
+// duration of 'ramp' after recovery, IsOver() allows only a fraction of retries which grows
+// linearly from zero to all retries, such that a fleet of clients does not re-overload a
+// backend which has barely recovered. Retries which are not allowed wait according to
+// Policy.BudgetOver as if the budget was still over.
+//
+//	budget := retry.NewRampBudget(retry.NewEWMABudget(0.1, 30*time.Second), 10*time.Second, nil)
+//
+// Rand is the source of randomness used to pick which retries are allowed, if nil the
+// math/rand top level functions are used. Rand must be safe for concurrent use; see SafeRand().
+//
+// The returned budget implements WeightedBudget by forwarding to the wrapped budget. It does
+// not implement BudgetRecoverer or BudgetSnapshotter, as the ramp is not reflected in either.
+func NewRampBudget(budget Budget, ramp time.Duration, rand Random) Budget {
+	return &rampBudget{
+		budget: budget,
+		ramp:   ramp,
+		rand:   rand,
+	}
+}
+
+func (r *rampBudget) IsOver(now time.Time) bool {
+	over := r.budget.IsOver(now)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if over {
+		r.over = true
+		return true
+	}
+	if r.over {
+		r.over = false
+		r.recovered = now
+	}
+	return r.random() >= r.allowed(now)
+}
+
+// allowed returns the fraction of retries allowed at 'now'
+func (r *rampBudget) allowed(now time.Time) float64 {
+	if r.recovered.IsZero() || r.ramp <= 0 {
+		return 1
+	}
+	elapsed := now.Sub(r.recovered)
+	if elapsed >= r.ramp {
+		return 1
+	}
+	return float64(elapsed) / float64(r.ramp)
+}
+
+func (r *rampBudget) random() float64 {
+	if r.rand != nil {
+		return r.rand.Float64()
+	}
+	return rand.Float64()
+}
+
+func (r *rampBudget) Success(now time.Time, hits int) {
+	r.budget.Success(now, hits)
+}
+
+func (r *rampBudget) Failure(now time.Time, hits int) {
+	r.budget.Failure(now, hits)
+}
+
+// FailureWeighted forwards the weighted failure to the wrapped budget
+func (r *rampBudget) FailureWeighted(now time.Time, weight float64) {
+	failureWeighted(r.budget, now, weight)
+}
+
+// Reset resets the wrapped budget and ends any ramp in progress
+func (r *rampBudget) Reset() {
+	r.mu.Lock()
+	r.over = false
+	r.recovered = time.Time{}
+	r.mu.Unlock()
+	r.budget.Reset()
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/stretchr/testify/assert"
+)
+
+// switchBudget is a Budget which is over while 'over' is true
+type switchBudget struct {
+	over bool
+}
+
+func (s *switchBudget) IsOver(time.Time) bool  { return s.over }
+func (s *switchBudget) Success(time.Time, int) {}
+func (s *switchBudget) Failure(time.Time, int) {}
+func (s *switchBudget) Reset()                 { s.over = false }
+
+func TestRampBudget(t *testing.T) {
+	start := time.Now()
+	inner := &switchBudget{}
+	b := retry.NewRampBudget(inner, 10*time.Second, retry.SafeRand(1))
+
+	// Returns the fraction of 1,000 retries allowed at 'offset' from the start
+	allowed := func(offset time.Duration) float64 {
+		var n int
+		for i := 0; i < 1000; i++ {
+			if !b.IsOver(start.Add(offset)) {
+				n++
+			}
+		}
+		return float64(n) / 1000
+	}
+
+	// Before the budget is ever over, every retry is allowed
+	assert.Equal(t, 1.0, allowed(0))
+
+	inner.over = true
+	assert.Equal(t, 0.0, allowed(time.Second))
+
+	// The budget recovers at 2s, after which the allowed fraction grows over the 10s ramp
+	inner.over = false
+	assert.Equal(t, 0.0, allowed(2*time.Second))
+	assert.InDelta(t, 0.25, allowed(4500*time.Millisecond), 0.05)
+	assert.InDelta(t, 0.5, allowed(7*time.Second), 0.05)
+	assert.InDelta(t, 0.75, allowed(9500*time.Millisecond), 0.05)
+	assert.Equal(t, 1.0, allowed(12*time.Second))
+	assert.Equal(t, 1.0, allowed(time.Minute))
+
+	t.Run("OverDuringRamp", func(t *testing.T) {
+		inner.over = true
+		allowed(time.Minute)
+		inner.over = false
+		assert.Equal(t, 0.0, allowed(time.Minute+time.Second))
+		assert.InDelta(t, 0.5, allowed(time.Minute+6*time.Second), 0.05)
+
+		// The budget going over again during the ramp restarts the ramp on recovery
+		inner.over = true
+		assert.Equal(t, 0.0, allowed(time.Minute+7*time.Second))
+		inner.over = false
+		assert.Equal(t, 0.0, allowed(time.Minute+8*time.Second))
+		assert.InDelta(t, 0.25, allowed(time.Minute+10500*time.Millisecond), 0.05)
+	})
+
+	t.Run("Weighted", func(t *testing.T) {
+		// Weighted failures reach the wrapped budget, such that Policy.BudgetWeight is not
+		// silently ignored
+		inner := retry.NewEWMABudget(0.5, time.Hour)
+		b := retry.NewRampBudget(inner, 10*time.Second, retry.SafeRand(1))
+		b.(retry.WeightedBudget).FailureWeighted(start, 0.3)
+		assert.Equal(t, 0.3, inner.(retry.BudgetSnapshotter).Snapshot(start).Failures)
+
+		// A wrapped budget which is not weighted counts whole hits
+		counting := &countingBudget{}
+		b = retry.NewRampBudget(counting, 10*time.Second, retry.SafeRand(1))
+		b.(retry.WeightedBudget).FailureWeighted(start, 0.3)
+		assert.Equal(t, 1, counting.failures)
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		inner.over = true
+		b.IsOver(start)
+		b.Reset()
+		assert.False(t, inner.over)
+		assert.Equal(t, 1.0, allowed(0))
+	})
+}
@@ -0,0 +1,689 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	duh "github.com/duh-rpc/duh.go/v2"
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucketBudget(t *testing.T) {
+	now := time.Now()
+	b := retry.NewTokenBucketBudget(1, 3)
+
+	assert.False(t, b.IsOver(now))
+	// Successes do not consume tokens
+	b.Success(now, 10)
+	assert.False(t, b.IsOver(now))
+
+	// Each failure consumes a token until the burst is exhausted
+	b.Failure(now, 1)
+	b.Failure(now, 1)
+	assert.False(t, b.IsOver(now))
+	b.Failure(now, 1)
+	assert.True(t, b.IsOver(now))
+
+	// Tokens refill at the configured rate
+	assert.True(t, b.IsOver(now.Add(500*time.Millisecond)))
+	assert.False(t, b.IsOver(now.Add(time.Second)))
+
+	// Tokens never refill beyond the burst
+	later := now.Add(time.Hour)
+	b.Failure(later, 3)
+	assert.True(t, b.IsOver(later))
+}
+
+func TestTokenBucketRecoversIn(t *testing.T) {
+	now := time.Now()
+	b := retry.NewTokenBucketBudget(2, 2)
+	r, ok := b.(retry.BudgetRecoverer)
+	require.True(t, ok)
+
+	// Not over
+	assert.Equal(t, time.Duration(0), r.RecoversIn(now))
+
+	// All tokens consumed, one token refills in half a second
+	b.Failure(now, 2)
+	assert.Equal(t, 500*time.Millisecond, r.RecoversIn(now))
+	assert.Equal(t, 250*time.Millisecond, r.RecoversIn(now.Add(250*time.Millisecond)))
+	assert.Equal(t, time.Duration(0), r.RecoversIn(now.Add(500*time.Millisecond)))
+
+	t.Run("NeverRecovers", func(t *testing.T) {
+		b := retry.NewTokenBucketBudget(0, 1)
+		b.Failure(now, 1)
+		assert.Equal(t, time.Duration(math.MaxInt64), b.(retry.BudgetRecoverer).RecoversIn(now))
+	})
+}
+
+func TestEWMABudget(t *testing.T) {
+	now := time.Now()
+	b := retry.NewEWMABudget(0.5, 10*time.Second)
+
+	assert.False(t, b.IsOver(now))
+	b.Success(now, 10)
+	b.Failure(now, 5)
+	assert.False(t, b.IsOver(now))
+	b.Failure(now, 1)
+	assert.True(t, b.IsOver(now))
+
+	t.Run("Smooth", func(t *testing.T) {
+		// Under an on/off failure pattern with a steady stream of successes, the budget
+		// should flip once as failures accumulate, and once as they decay. It should not
+		// oscillate each time the failure pattern toggles.
+		b := retry.NewEWMABudget(0.3, 10*time.Second)
+		start := time.Now()
+		var transitions int
+		var over bool
+		for i := 0; i < 120; i++ {
+			now := start.Add(time.Duration(i) * time.Second)
+			b.Success(now, 10)
+			// 10 seconds of failures, 10 seconds without, for the first minute
+			if i < 60 && (i/10)%2 == 0 {
+				b.Failure(now, 10)
+			}
+			if b.IsOver(now) != over {
+				over = !over
+				transitions++
+			}
+		}
+		assert.False(t, over)
+		assert.Equal(t, 2, transitions)
+	})
+
+	t.Run("RecoversWithoutHits", func(t *testing.T) {
+		// A ratio of 0.5 is well over the threshold, and decay alone does not change the
+		// ratio. The budget must still recover once the failures decay below the floor,
+		// otherwise a caller blocked on the budget would never make another attempt.
+		b := retry.NewEWMABudget(0.1, 30*time.Second)
+		b.Success(now, 20)
+		b.Failure(now, 10)
+		assert.True(t, b.IsOver(now))
+
+		r := b.(retry.BudgetRecoverer)
+		// log2(10 / 1) half lives
+		recovers := r.RecoversIn(now)
+		assert.InDelta(t, 99.6, recovers.Seconds(), 0.1)
+		assert.True(t, b.IsOver(now.Add(time.Minute)))
+		assert.False(t, b.IsOver(now.Add(recovers+time.Millisecond)))
+		assert.Equal(t, time.Duration(0), r.RecoversIn(now.Add(time.Hour)))
+
+		// Without a floor the budget only recovers once successes are recorded
+		b = retry.NewEWMABudget(0.1, 30*time.Second, retry.WithFailureFloor(0))
+		b.Failure(now, 1)
+		assert.Equal(t, time.Duration(math.MaxInt64), b.(retry.BudgetRecoverer).RecoversIn(now))
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		b.Reset()
+		assert.False(t, b.IsOver(now))
+	})
+}
+
+func TestSlidingLogBudget(t *testing.T) {
+	start := time.Now()
+	b := retry.NewSlidingLogBudget(0.3, 10*time.Second)
+	ewma := retry.NewEWMABudget(0.3, 10*time.Second)
+
+	for _, budget := range []retry.Budget{b, ewma} {
+		budget.Failure(start, 6)
+		budget.Success(start.Add(5*time.Second), 10)
+	}
+	assert.True(t, b.IsOver(start.Add(9*time.Second)))
+	assert.True(t, ewma.IsOver(start.Add(9*time.Second)))
+	assert.Equal(t, 5*time.Second, b.(retry.BudgetRecoverer).RecoversIn(start.Add(5*time.Second)))
+
+	// Once the failures leave the window the sliding log forgets them entirely, while the
+	// EWMA approximation still counts half their weight.
+	now := start.Add(10 * time.Second)
+	assert.False(t, b.IsOver(now))
+	assert.True(t, ewma.IsOver(now))
+	assert.Equal(t, time.Duration(0), b.(retry.BudgetRecoverer).RecoversIn(now))
+
+	t.Run("OutOfOrder", func(t *testing.T) {
+		// Concurrent callers may record hits with a time older than the last hit recorded,
+		// which must still expire when they leave the window.
+		b := retry.NewSlidingLogBudget(0.5, 10*time.Second)
+		b.Failure(start.Add(5*time.Second), 2)
+		b.Success(start, 10)
+		assert.False(t, b.IsOver(start.Add(5*time.Second)))
+
+		now := start.Add(10 * time.Second)
+		assert.True(t, b.IsOver(now))
+		assert.Equal(t, 5*time.Second, b.(retry.BudgetRecoverer).RecoversIn(now))
+	})
+
+	t.Run("Weighted", func(t *testing.T) {
+		b := retry.NewSlidingLogBudget(0.5, time.Minute)
+		b.Success(now, 2)
+		b.(retry.WeightedBudget).FailureWeighted(now, 0.5)
+		b.(retry.WeightedBudget).FailureWeighted(now, 0.5)
+		assert.False(t, b.IsOver(now))
+		b.(retry.WeightedBudget).FailureWeighted(now, 0.5)
+		assert.True(t, b.IsOver(now))
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		b := retry.NewSlidingLogBudget(0.5, time.Minute)
+		b.Failure(now, 2)
+		assert.True(t, b.IsOver(now))
+		b.Reset()
+		assert.False(t, b.IsOver(now))
+	})
+}
+
+func TestBudgetFailureFloor(t *testing.T) {
+	now := time.Now()
+	for _, tt := range []struct {
+		name   string
+		create func(opts ...retry.BudgetOption) retry.Budget
+	}{
+		{
+			name: "EWMA",
+			create: func(opts ...retry.BudgetOption) retry.Budget {
+				return retry.NewEWMABudget(0.1, time.Minute, opts...)
+			},
+		},
+		{
+			name: "SlidingLog",
+			create: func(opts ...retry.BudgetOption) retry.Budget {
+				return retry.NewSlidingLogBudget(0.1, time.Minute, opts...)
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// The very first failure on a fresh budget does not block retries
+			b := tt.create()
+			b.Failure(now, 1)
+			assert.False(t, b.IsOver(now))
+			b.Failure(now, 1)
+			assert.True(t, b.IsOver(now))
+
+			// The failures must exceed both the floor and the ratio of successes
+			b = tt.create(retry.WithFailureFloor(5))
+			b.Failure(now, 5)
+			assert.False(t, b.IsOver(now))
+			b.Success(now, 10)
+			assert.False(t, b.IsOver(now))
+			b.Failure(now, 1)
+			assert.True(t, b.IsOver(now))
+
+			// A floor of zero restores the strict ratio on a cold budget
+			b = tt.create(retry.WithFailureFloor(0))
+			b.Failure(now, 1)
+			assert.True(t, b.IsOver(now))
+		})
+	}
+}
+
+func TestBudgetFailureFloorAfterIdle(t *testing.T) {
+	// The successes of an idle EWMA budget decay toward zero but never reach it, the floor
+	// must still protect against a single failure once traffic resumes.
+	start := time.Now()
+	b := retry.NewEWMABudget(0.1, 30*time.Second)
+	b.Success(start, 100)
+
+	now := start.Add(10 * time.Minute)
+	b.Failure(now, 1)
+	assert.False(t, b.IsOver(now))
+	b.Failure(now, 1)
+	assert.True(t, b.IsOver(now))
+}
+
+func TestBudgetSnapshot(t *testing.T) {
+	start := time.Now()
+	for _, tt := range []struct {
+		name   string
+		budget retry.Budget
+	}{
+		{name: "EWMA", budget: retry.NewEWMABudget(0.5, 10*time.Second)},
+		{name: "SlidingLog", budget: retry.NewSlidingLogBudget(0.5, 10*time.Second)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.budget
+			snapshotter := b.(retry.BudgetSnapshotter)
+			assert.Equal(t, retry.BudgetSnapshot{Threshold: 0.5, Floor: 1}, snapshotter.Snapshot(start))
+
+			b.Success(start, 10)
+			b.Failure(start, 4)
+			assert.Equal(t, retry.BudgetSnapshot{
+				Successes: 10,
+				Failures:  4,
+				Ratio:     0.4,
+				Threshold: 0.5,
+				Floor:     1,
+			}, snapshotter.Snapshot(start))
+
+			// Over matches IsOver() for the same instant as hits are recorded and age
+			for i := 0; i < 20; i++ {
+				now := start.Add(time.Duration(i) * time.Second)
+				if i < 5 {
+					b.Failure(now, 1)
+				}
+				assert.Equal(t, b.IsOver(now), snapshotter.Snapshot(now).Over)
+			}
+		})
+	}
+
+	t.Run("NoSuccesses", func(t *testing.T) {
+		// Failures with no successes exceed any threshold, which the ratio must reflect
+		// while the floor shows why the budget is not yet over.
+		b := retry.NewEWMABudget(0.5, 10*time.Second)
+		b.Failure(start, 1)
+		s := b.(retry.BudgetSnapshotter).Snapshot(start)
+		assert.True(t, math.IsInf(s.Ratio, 1))
+		assert.Equal(t, float64(1), s.Floor)
+		assert.False(t, s.Over)
+
+		b.Failure(start, 1)
+		s = b.(retry.BudgetSnapshotter).Snapshot(start)
+		assert.True(t, math.IsInf(s.Ratio, 1))
+		assert.True(t, s.Over)
+	})
+}
+
+func TestBudgetReset(t *testing.T) {
+	now := time.Now()
+	b := retry.NewTokenBucketBudget(1, 2)
+	b.Failure(now, 2)
+	assert.True(t, b.IsOver(now))
+
+	b.Reset()
+	assert.False(t, b.IsOver(now))
+}
+
+func TestPolicyBudget(t *testing.T) {
+	// A budget with a single token and a quick refill will allow one immediate
+	// retry, then block further retries until a token is available.
+	policy := retry.Policy{
+		Interval: retry.Sleep(10 * time.Millisecond),
+		Budget:   retry.NewTokenBucketBudget(10, 1),
+		Attempts: 3,
+	}
+
+	var count int
+	start := time.Now()
+	err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		count++
+		return errors.New("fail")
+	})
+	require.Error(t, err)
+	assert.Equal(t, 3, count)
+	// Two retries require a refill of at least one token at 10 tokens per second
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+type countingBudget struct {
+	successes int
+	failures  int
+}
+
+func (c *countingBudget) IsOver(time.Time) bool         { return false }
+func (c *countingBudget) Success(_ time.Time, hits int) { c.successes += hits }
+func (c *countingBudget) Failure(_ time.Time, hits int) { c.failures += hits }
+func (c *countingBudget) Reset()                        { c.successes, c.failures = 0, 0 }
+
+func TestBudgetRetrySuccessOnly(t *testing.T) {
+	run := func(retrySuccessOnly bool) *countingBudget {
+		budget := &countingBudget{}
+		policy := retry.Policy{
+			Interval:               retry.Sleep(time.Millisecond),
+			Budget:                 budget,
+			BudgetRetrySuccessOnly: retrySuccessOnly,
+			Clock:                  &fakeClock{now: time.Now()},
+			Attempts:               2,
+		}
+
+		// 10 calls which succeed on the first attempt
+		for i := 0; i < 10; i++ {
+			require.NoError(t, retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+				return nil
+			}))
+		}
+		// 2 calls which succeed after a single retry
+		for i := 0; i < 2; i++ {
+			require.NoError(t, retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+				if attempt == 1 {
+					return retry.Retryable(errors.New("fail"))
+				}
+				return nil
+			}))
+		}
+		return budget
+	}
+
+	// First attempt successes hide the fact that every retried call failed once
+	all := run(false)
+	assert.Equal(t, 12, all.successes)
+	assert.Equal(t, 2, all.failures)
+	assert.InDelta(t, 0.16, float64(all.failures)/float64(all.successes), 0.01)
+
+	// Counting only successes on retry, the ratio reflects the health of the retry path
+	retried := run(true)
+	assert.Equal(t, 2, retried.successes)
+	assert.Equal(t, 2, retried.failures)
+	assert.Equal(t, 1.0, float64(retried.failures)/float64(retried.successes))
+}
+
+func TestBudgetWeightByAttempt(t *testing.T) {
+	// Returns the call, each failing 3 attempts, on which the budget trips
+	run := func(byAttempt bool) int {
+		budget := retry.NewTokenBucketBudget(0, 30)
+		policy := retry.Policy{
+			Interval:              retry.Sleep(time.Millisecond),
+			Budget:                budget,
+			BudgetWeightByAttempt: byAttempt,
+			BudgetOver:            retry.BudgetFailFast,
+			Clock:                 &fakeClock{now: time.Now()},
+			Attempts:              3,
+		}
+		for calls := 1; ; calls++ {
+			err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+				return retry.Retryable(errors.New("fail"))
+			})
+			if errors.Is(err, retry.ErrBudgetExhausted) {
+				return calls
+			}
+		}
+	}
+
+	// Each call consumes 3 tokens when every failure counts as 1, and 1+2+3 = 6 tokens when
+	// weighted by attempt, so the budget is drained by half as many calls and the next call
+	// fails fast.
+	assert.Equal(t, 11, run(false))
+	assert.Equal(t, 6, run(true))
+
+	t.Run("Counting", func(t *testing.T) {
+		budget := &countingBudget{}
+		policy := retry.Policy{
+			Interval:              retry.Sleep(time.Millisecond),
+			Budget:                budget,
+			BudgetWeightByAttempt: true,
+			Clock:                 &fakeClock{now: time.Now()},
+			Attempts:              4,
+		}
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			return retry.Retryable(errors.New("fail"))
+		})
+		require.Error(t, err)
+		assert.Equal(t, 1+2+3+4, budget.failures)
+	})
+}
+
+func TestBudgetWeightedFailures(t *testing.T) {
+	now := time.Now()
+	budget := retry.NewEWMABudget(0.5, time.Hour)
+	weighted := budget.(retry.WeightedBudget)
+
+	budget.Success(now, 10)
+	// 4 hard failures and 3 soft failures weigh 4.9, under the 0.5 ratio of 10 successes
+	budget.Failure(now, 4)
+	for i := 0; i < 3; i++ {
+		weighted.FailureWeighted(now, 0.3)
+	}
+	assert.False(t, budget.IsOver(now))
+	// A single additional soft failure tips the ratio over
+	weighted.FailureWeighted(now, 0.3)
+	assert.True(t, budget.IsOver(now))
+
+	t.Run("TokenBucket", func(t *testing.T) {
+		budget := retry.NewTokenBucketBudget(0, 2)
+		weighted := budget.(retry.WeightedBudget)
+		// Two soft failures consume a single token
+		weighted.FailureWeighted(now, 0.5)
+		weighted.FailureWeighted(now, 0.5)
+		assert.False(t, budget.IsOver(now))
+		weighted.FailureWeighted(now, 0.5)
+		assert.True(t, budget.IsOver(now))
+	})
+
+	t.Run("Policy", func(t *testing.T) {
+		budget := retry.NewEWMABudget(0.5, time.Hour)
+		budget.Success(time.Now(), 2)
+		policy := retry.Policy{
+			Interval: retry.Sleep(time.Millisecond),
+			Budget:   budget,
+			BudgetWeight: func(err error) float64 {
+				var te *testError
+				if errors.As(err, &te) && te.httpCode == duh.CodeTooManyRequests {
+					return 0.3
+				}
+				return 1
+			},
+			Attempts: 3,
+		}
+		// 3 soft failures weigh 0.9, under the 0.5 ratio of 2 successes
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			return &testError{code: "429", httpCode: duh.CodeTooManyRequests}
+		})
+		require.Error(t, err)
+		assert.False(t, budget.IsOver(time.Now()))
+	})
+}
+
+func TestBudgetIgnoresNonRetryable(t *testing.T) {
+	budget := retry.NewTokenBucketBudget(0, 1)
+	policy := retry.Policy{
+		OnCodes:  []int{duh.CodeTooManyRequests},
+		Interval: retry.Sleep(time.Millisecond),
+		Budget:   budget,
+		Attempts: 3,
+	}
+
+	for i := 0; i < 10; i++ {
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			return &testError{code: "400", httpCode: duh.CodeBadRequest}
+		})
+		require.Error(t, err)
+	}
+	assert.False(t, budget.IsOver(time.Now()))
+}
+
+func TestBudgetFirstAttempt(t *testing.T) {
+	op := func(ctx context.Context, attempt int) error { return nil }
+
+	t.Run("ColdStart", func(t *testing.T) {
+		clock := &fakeClock{now: time.Now()}
+		policy := retry.Policy{
+			Interval:           retry.Sleep(time.Second),
+			Budget:             retry.NewTokenBucketBudget(1, 1),
+			BudgetFirstAttempt: true,
+			Clock:              clock,
+		}
+		require.NoError(t, retry.On(context.Background(), policy, op))
+		assert.Empty(t, clock.sleeps)
+	})
+
+	t.Run("OverBudgetDefault", func(t *testing.T) {
+		clock := &fakeClock{now: time.Now()}
+		budget := retry.NewTokenBucketBudget(1, 1)
+		budget.Failure(clock.Now(), 1)
+		policy := retry.Policy{
+			Interval: retry.Sleep(500 * time.Millisecond),
+			Budget:   budget,
+			Clock:    clock,
+		}
+		require.NoError(t, retry.On(context.Background(), policy, op))
+		assert.Empty(t, clock.sleeps)
+	})
+
+	t.Run("OverBudgetGated", func(t *testing.T) {
+		clock := &fakeClock{now: time.Now()}
+		budget := retry.NewTokenBucketBudget(1, 1)
+		budget.Failure(clock.Now(), 1)
+		policy := retry.Policy{
+			Interval:           retry.Sleep(500 * time.Millisecond),
+			Budget:             budget,
+			BudgetFirstAttempt: true,
+			Clock:              clock,
+		}
+		require.NoError(t, retry.On(context.Background(), policy, op))
+		assert.Equal(t, []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}, clock.sleeps)
+	})
+}
+
+func TestBudgetWaitTimeout(t *testing.T) {
+	// A budget which never refills will block forever without a timeout
+	clock := &fakeClock{now: time.Now()}
+	policy := retry.Policy{
+		Interval:          retry.Sleep(time.Second),
+		Budget:            retry.NewTokenBucketBudget(0, 1),
+		BudgetWaitTimeout: 2500 * time.Millisecond,
+		Clock:             clock,
+	}
+
+	var count int
+	err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		count++
+		return errors.New("fail")
+	})
+	require.ErrorIs(t, err, retry.ErrBudgetExhausted)
+	assert.Equal(t, 1, count)
+	// The retry sleep, followed by budget blocked sleeps which never exceed the timeout
+	assert.Equal(t, []time.Duration{
+		time.Second,
+		time.Second, time.Second, 500 * time.Millisecond,
+	}, clock.sleeps)
+}
+
+func TestBudgetOverBehavior(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		behavior retry.BudgetOverBehavior
+		sleeps   []time.Duration
+	}{
+		{
+			name:     "Block",
+			behavior: retry.BudgetBlock,
+			// The retry sleep, followed by budget blocked sleeps until the wait timeout
+			sleeps: []time.Duration{time.Second, time.Second, time.Second},
+		},
+		{
+			name:     "FailFast",
+			behavior: retry.BudgetFailFast,
+			// The failure which leaves the budget over ends the loop without sleeping
+			sleeps: nil,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Now()}
+			policy := retry.Policy{
+				Interval:          retry.Sleep(time.Second),
+				Budget:            retry.NewTokenBucketBudget(0, 1),
+				BudgetWaitTimeout: 2 * time.Second,
+				BudgetOver:        tt.behavior,
+				Clock:             clock,
+			}
+
+			var count int
+			err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+				count++
+				return errors.New("fail")
+			})
+			require.ErrorIs(t, err, retry.ErrBudgetExhausted)
+			assert.Equal(t, 1, count)
+			assert.Equal(t, tt.sleeps, clock.sleeps)
+		})
+	}
+
+	t.Run("FailFastFirstAttempt", func(t *testing.T) {
+		budget := retry.NewTokenBucketBudget(0, 1)
+		budget.Failure(time.Now(), 1)
+		policy := retry.Policy{
+			Interval:           retry.Sleep(time.Second),
+			Budget:             budget,
+			BudgetFirstAttempt: true,
+			BudgetOver:         retry.BudgetFailFast,
+		}
+
+		var count int
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			count++
+			return nil
+		})
+		require.ErrorIs(t, err, retry.ErrBudgetExhausted)
+		assert.Equal(t, 0, count)
+	})
+}
+
+func TestBudgetBlockedStats(t *testing.T) {
+	budget := retry.NewTokenBucketBudget(0, 1)
+	budget.Failure(time.Now(), 1)
+	policy := retry.Policy{
+		Interval:           retry.Sleep(time.Second),
+		Budget:             budget,
+		BudgetFirstAttempt: true,
+		BudgetWaitTimeout:  3 * time.Second,
+		Clock:              &fakeClock{now: time.Now()},
+	}
+
+	var count int
+	stats, err := retry.OnWithStats(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		count++
+		return nil
+	})
+	require.ErrorIs(t, err, retry.ErrBudgetExhausted)
+	// The operation was never called, every iteration was blocked by the budget
+	assert.Equal(t, 0, count)
+	assert.Equal(t, 0, stats.Attempts)
+	assert.Equal(t, 3, stats.Blocked)
+	assert.Equal(t, 3*time.Second, stats.TotalSleep)
+}
+
+func TestBudgetBlockedDoesNotInflateBackOff(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	r := retry.NewRetrier(retry.Policy{
+		Interval: retry.BackOff{
+			Min:    time.Second,
+			Max:    time.Minute,
+			Factor: 2,
+			Mode:   retry.JitterNone,
+		},
+		Budget:   retry.NewTokenBucketBudget(0.1, 1),
+		Clock:    clock,
+		Attempts: 3,
+	})
+
+	var attempts []int
+	for {
+		attempt, ok := r.Next(context.Background())
+		if !ok {
+			break
+		}
+		attempts = append(attempts, attempt)
+		r.Record(errors.New("fail"))
+	}
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+	assert.Equal(t, 3, r.Blocked())
+	// After the budget recovers, the retry sleep after attempt 2 is 4s and is not
+	// inflated by the blocked sleeps which came before it.
+	assert.Equal(t, []time.Duration{
+		// Retry sleep after attempt 1
+		2 * time.Second,
+		// Blocked sleeps before attempt 2
+		4 * time.Second, 4 * time.Second,
+		// Retry sleep after attempt 2
+		4 * time.Second,
+		// Blocked sleep before attempt 3
+		8 * time.Second,
+	}, clock.sleeps)
+}
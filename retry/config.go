@@ -0,0 +1,179 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PolicyConfig is a serializable description of a Policy with a BackOff interval, suitable
+// for loading from JSON, YAML or an environment variable. Durations are strings parsed by
+// time.ParseDuration(), such as "500ms". Fields left empty default to the value from
+// DefaultBackOff. Call Build() to create the Policy.
+//
+//	{"min": "500ms", "max": "1m", "factor": 1.5, "jitter": 0.5, "attempts": 5, "codes": [429, 503]}
+//
+// The same config can be provided as text, which is convenient for environment variables.
+//
+//	RETRY_POLICY="min=500ms max=1m factor=1.5 jitter=0.5 attempts=5 codes=429,503"
+type PolicyConfig struct {
+	Min    string  `json:"min,omitempty" yaml:"min,omitempty"`
+	Max    string  `json:"max,omitempty" yaml:"max,omitempty"`
+	Factor float64 `json:"factor,omitempty" yaml:"factor,omitempty"`
+	// Jitter is a pointer such that an explicit 0 disables jitter, while leaving it unset
+	// uses the jitter from DefaultBackOff
+	Jitter *float64 `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+	// Mode is the name of a JitterMode as returned by JitterMode.String(), such as "full"
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+	// MaxSleep is the hard ceiling on every sleep, see BackOff.MaxSleep
+	MaxSleep   string `json:"max_sleep,omitempty" yaml:"max_sleep,omitempty"`
+	Attempts   int    `json:"attempts,omitempty" yaml:"attempts,omitempty"`
+	Codes      []int  `json:"codes,omitempty" yaml:"codes,omitempty"`
+	InfraCodes []int  `json:"infra_codes,omitempty" yaml:"infra_codes,omitempty"`
+}
+
+// Build parses the config and returns a validated Policy. See Policy.Validate() for the
+// rules applied.
+func (c PolicyConfig) Build() (Policy, error) {
+	b := DefaultBackOff
+	if c.Min != "" {
+		d, err := time.ParseDuration(c.Min)
+		if err != nil {
+			return Policy{}, fmt.Errorf("PolicyConfig.Min: %w", err)
+		}
+		b.Min = d
+	}
+	if c.Max != "" {
+		d, err := time.ParseDuration(c.Max)
+		if err != nil {
+			return Policy{}, fmt.Errorf("PolicyConfig.Max: %w", err)
+		}
+		b.Max = d
+	}
+	if c.Factor != 0 {
+		b.Factor = c.Factor
+	}
+	if c.Jitter != nil {
+		b.Jitter = *c.Jitter
+	}
+	if c.Mode != "" {
+		m, err := parseJitterMode(c.Mode)
+		if err != nil {
+			return Policy{}, fmt.Errorf("PolicyConfig.Mode: %w", err)
+		}
+		b.Mode = m
+	}
+	if c.MaxSleep != "" {
+		d, err := time.ParseDuration(c.MaxSleep)
+		if err != nil {
+			return Policy{}, fmt.Errorf("PolicyConfig.MaxSleep: %w", err)
+		}
+		b.MaxSleep = d
+	}
+	return NewPolicy(
+		WithInterval(b),
+		WithAttempts(c.Attempts),
+		WithCodes(c.Codes...),
+		WithInfraCodes(c.InfraCodes...),
+	)
+}
+
+// UnmarshalJSON decodes the config from either a JSON object or a JSON string in the text
+// form accepted by UnmarshalText()
+func (c *PolicyConfig) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		return c.UnmarshalText([]byte(text))
+	}
+	// The alias has no methods, which avoids recursing into UnmarshalJSON
+	type config PolicyConfig
+	var out config
+	if err := json.Unmarshal(data, &out); err != nil {
+		return err
+	}
+	*c = PolicyConfig(out)
+	return nil
+}
+
+// UnmarshalText decodes the config from space separated key=value pairs, where the keys are
+// the JSON field names and codes are separated by commas.
+//
+//	min=500ms max=1m factor=1.5 jitter=0.5 mode=full max_sleep=2m attempts=5 codes=429,503 infra_codes=502
+func (c *PolicyConfig) UnmarshalText(text []byte) error {
+	var out PolicyConfig
+	for _, field := range strings.Fields(string(text)) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return fmt.Errorf("PolicyConfig: expected key=value; got '%s'", field)
+		}
+		var err error
+		switch key {
+		case "min":
+			out.Min = value
+		case "max":
+			out.Max = value
+		case "factor":
+			out.Factor, err = strconv.ParseFloat(value, 64)
+		case "jitter":
+			var jitter float64
+			jitter, err = strconv.ParseFloat(value, 64)
+			out.Jitter = &jitter
+		case "mode":
+			out.Mode = value
+		case "max_sleep":
+			out.MaxSleep = value
+		case "attempts":
+			out.Attempts, err = strconv.Atoi(value)
+		case "codes":
+			out.Codes, err = parseCodes(value)
+		case "infra_codes":
+			out.InfraCodes, err = parseCodes(value)
+		default:
+			return fmt.Errorf("PolicyConfig: unknown key '%s'", key)
+		}
+		if err != nil {
+			return fmt.Errorf("PolicyConfig: invalid %s: %w", key, err)
+		}
+	}
+	*c = out
+	return nil
+}
+
+// parseCodes parses a comma separated list of codes
+func parseCodes(s string) ([]int, error) {
+	var codes []int
+	for _, part := range strings.Split(s, ",") {
+		code, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// parseJitterMode returns the JitterMode with the provided name
+func parseJitterMode(name string) (JitterMode, error) {
+	for m := JitterScaled; m <= JitterCentered; m++ {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown jitter mode '%s'", name)
+}
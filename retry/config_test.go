@@ -0,0 +1,168 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	duh "github.com/duh-rpc/duh.go/v2"
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPolicyConfig(t *testing.T) {
+	jitter := 0.5
+	expected := retry.PolicyConfig{
+		Min:      "500ms",
+		Max:      "1m",
+		Factor:   1.5,
+		Jitter:   &jitter,
+		Attempts: 5,
+		Codes:    []int{429, 503},
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		var c retry.PolicyConfig
+		require.NoError(t, json.Unmarshal([]byte(`{"min": "500ms", "max": "1m", "factor": 1.5,
+			"jitter": 0.5, "attempts": 5, "codes": [429, 503]}`), &c))
+		assert.Equal(t, expected, c)
+	})
+
+	t.Run("JSONString", func(t *testing.T) {
+		var c retry.PolicyConfig
+		require.NoError(t, json.Unmarshal([]byte(`"min=500ms max=1m factor=1.5 jitter=0.5 attempts=5 codes=429,503"`), &c))
+		assert.Equal(t, expected, c)
+	})
+
+	t.Run("YAML", func(t *testing.T) {
+		var c retry.PolicyConfig
+		require.NoError(t, yaml.Unmarshal([]byte("min: 500ms\nmax: 1m\nfactor: 1.5\n"+
+			"jitter: 0.5\nattempts: 5\ncodes: [429, 503]\n"), &c))
+		assert.Equal(t, expected, c)
+
+		c = retry.PolicyConfig{}
+		require.NoError(t, yaml.Unmarshal([]byte(`"min=500ms max=1m factor=1.5 jitter=0.5 attempts=5 codes=429,503"`), &c))
+		assert.Equal(t, expected, c)
+	})
+
+	t.Run("Text", func(t *testing.T) {
+		var c retry.PolicyConfig
+		require.NoError(t, c.UnmarshalText([]byte("min=500ms max=1m factor=1.5 jitter=0.5 attempts=5 codes=429,503")))
+		assert.Equal(t, expected, c)
+
+		assert.EqualError(t, c.UnmarshalText([]byte("min")), "PolicyConfig: expected key=value; got 'min'")
+		assert.EqualError(t, c.UnmarshalText([]byte("foo=1")), "PolicyConfig: unknown key 'foo'")
+		assert.EqualError(t, c.UnmarshalText([]byte("codes=429,abc")),
+			`PolicyConfig: invalid codes: strconv.Atoi: parsing "abc": invalid syntax`)
+	})
+
+	t.Run("Build", func(t *testing.T) {
+		p, err := expected.Build()
+		require.NoError(t, err)
+		assert.Equal(t, retry.BackOff{
+			Min:    500 * time.Millisecond,
+			Max:    time.Minute,
+			Factor: 1.5,
+			Jitter: 0.5,
+		}, p.Interval)
+		assert.Equal(t, 5, p.Attempts)
+		assert.Equal(t, []int{429, 503}, p.OnCodes)
+
+		// The policy retries the configured codes
+		p.Clock = &fakeClock{now: time.Now()}
+		var count int
+		err = retry.On(context.Background(), p, func(ctx context.Context, attempt int) error {
+			count++
+			if attempt < 3 {
+				return &testError{code: "429", httpCode: duh.CodeTooManyRequests}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, count)
+	})
+
+	t.Run("Defaults", func(t *testing.T) {
+		p, err := retry.PolicyConfig{}.Build()
+		require.NoError(t, err)
+		assert.Equal(t, retry.DefaultBackOff, p.Interval)
+	})
+
+	t.Run("NoJitter", func(t *testing.T) {
+		// An explicit zero disables jitter instead of using the default
+		for _, data := range []string{`{"jitter": 0}`, `"jitter=0"`} {
+			var c retry.PolicyConfig
+			require.NoError(t, json.Unmarshal([]byte(data), &c))
+			p, err := c.Build()
+			require.NoError(t, err)
+			assert.Equal(t, float64(0), p.Interval.(retry.BackOff).Jitter)
+		}
+
+		var c retry.PolicyConfig
+		require.NoError(t, yaml.Unmarshal([]byte("jitter: 0\n"), &c))
+		p, err := c.Build()
+		require.NoError(t, err)
+		assert.Equal(t, float64(0), p.Interval.(retry.BackOff).Jitter)
+	})
+
+	t.Run("ModeAndMaxSleep", func(t *testing.T) {
+		var c retry.PolicyConfig
+		require.NoError(t, c.UnmarshalText([]byte("mode=full max_sleep=2m")))
+		assert.Equal(t, retry.PolicyConfig{Mode: "full", MaxSleep: "2m"}, c)
+
+		p, err := c.Build()
+		require.NoError(t, err)
+		b := p.Interval.(retry.BackOff)
+		assert.Equal(t, retry.JitterFull, b.Mode)
+		assert.Equal(t, 2*time.Minute, b.MaxSleep)
+	})
+
+	for _, tt := range []struct {
+		name   string
+		config retry.PolicyConfig
+		err    string
+	}{
+		{
+			name:   "InvalidDuration",
+			config: retry.PolicyConfig{Min: "fast"},
+			err:    `PolicyConfig.Min: time: invalid duration "fast"`,
+		},
+		{
+			name:   "InvalidMode",
+			config: retry.PolicyConfig{Mode: "random"},
+			err:    "PolicyConfig.Mode: unknown jitter mode 'random'",
+		},
+		{
+			name:   "InvalidMaxSleep",
+			config: retry.PolicyConfig{MaxSleep: "long"},
+			err:    `PolicyConfig.MaxSleep: time: invalid duration "long"`,
+		},
+		{
+			name:   "InvalidPolicy",
+			config: retry.PolicyConfig{Min: "1m", Max: "1s"},
+			err:    "BackOff.Max '1s' must not be less than BackOff.Min '1m0s'",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.config.Build()
+			require.EqualError(t, err, tt.err)
+		})
+	}
+}
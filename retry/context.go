@@ -0,0 +1,74 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import "context"
+
+type maxAttemptsKey struct{}
+
+// WithMaxAttempts returns a copy of ctx which overrides Policy.Attempts for any On(), OnResult()
+// or Retrier which is started with the returned context. The context override always takes
+// precedence over the Policy, which allows a single request to opt out of retries without
+// building a new Policy.
+//
+//	// Latency sensitive request, do not retry
+//	err := retry.On(retry.WithMaxAttempts(ctx, 1), policy, op)
+func WithMaxAttempts(ctx context.Context, attempts int) context.Context {
+	return context.WithValue(ctx, maxAttemptsKey{}, attempts)
+}
+
+// maxAttempts returns the attempts override stored in ctx by WithMaxAttempts(), if any
+func maxAttempts(ctx context.Context) (int, bool) {
+	n, ok := ctx.Value(maxAttemptsKey{}).(int)
+	return n, ok
+}
+
+type budgetKey struct{}
+
+// WithBudgetKey returns a copy of ctx which selects the Budget from Policy.BudgetGroup for any
+// On(), OnResult() or Retrier which is started with the returned context. This allows a single
+// Policy to be shared by calls to many backends, while each backend has its own Budget.
+//
+//	err := retry.On(retry.WithBudgetKey(ctx, "backend-a"), policy, op)
+func WithBudgetKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, budgetKey{}, key)
+}
+
+// budgetKeyFrom returns the budget key stored in ctx by WithBudgetKey(), if any
+func budgetKeyFrom(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(budgetKey{}).(string)
+	return key, ok
+}
+
+type idempotentKey struct{}
+
+// WithIdempotent returns a copy of ctx which marks whether the operation of any On(),
+// OnResult() or Retrier started with the returned context is idempotent. An operation marked
+// as not idempotent is never retried, as repeating it could duplicate side effects, and the
+// error from the first attempt is returned. When Policy.RequireIdempotent is set, only an
+// operation marked as idempotent is retried.
+//
+//	// A GET is safe to retry, a POST is not
+//	ctx = retry.WithIdempotent(ctx, req.Method == http.MethodGet)
+//	err := retry.On(ctx, policy, op)
+func WithIdempotent(ctx context.Context, idempotent bool) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, idempotent)
+}
+
+// idempotentFrom returns the idempotency stored in ctx by WithIdempotent(), if any
+func idempotentFrom(ctx context.Context) (bool, bool) {
+	idempotent, ok := ctx.Value(idempotentKey{}).(bool)
+	return idempotent, ok
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// String returns a description of the policy suitable for logging which client is configured
+// with which policy, such as
+//
+//	backoff min=500ms max=5s factor=2 jitter=0.2 attempts=∞ codes=[429,503]
+func (p Policy) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v attempts=%s", p.Interval, formatAttempts(p.Attempts))
+	if len(p.OnCodes) != 0 {
+		fmt.Fprintf(&b, " codes=%s", formatCodes(p.OnCodes))
+	}
+	if len(p.OnInfraCodes) != 0 {
+		fmt.Fprintf(&b, " infra_codes=%s", formatCodes(p.OnInfraCodes))
+	}
+	if p.Budget != nil {
+		fmt.Fprintf(&b, " budget=(%v)", p.Budget)
+	}
+	if p.BudgetGroup != nil {
+		b.WriteString(" budget_group")
+	}
+	return b.String()
+}
+
+// MarshalJSON encodes a description of the policy, the Interval and Budget are encoded using
+// their String() descriptions as they are interfaces which may hold functions or state.
+func (p Policy) MarshalJSON() ([]byte, error) {
+	j := struct {
+		Interval   string `json:"interval"`
+		Attempts   int    `json:"attempts"`
+		Codes      []int  `json:"codes,omitempty"`
+		InfraCodes []int  `json:"infra_codes,omitempty"`
+		Budget     string `json:"budget,omitempty"`
+	}{
+		Interval:   fmt.Sprint(p.Interval),
+		Attempts:   p.Attempts,
+		Codes:      p.OnCodes,
+		InfraCodes: p.OnInfraCodes,
+	}
+	if p.Budget != nil {
+		j.Budget = fmt.Sprint(p.Budget)
+	}
+	return json.Marshal(j)
+}
+
+func (m JitterMode) String() string {
+	switch m {
+	case JitterScaled:
+		return "scaled"
+	case JitterSymmetric:
+		return "symmetric"
+	case JitterFull:
+		return "full"
+	case JitterEqual:
+		return "equal"
+	case JitterNone:
+		return "none"
+	case JitterCentered:
+		return "centered"
+	}
+	return "JitterMode(" + strconv.Itoa(int(m)) + ")"
+}
+
+func (b BackOff) String() string {
+	s := fmt.Sprintf("backoff min=%s max=%s factor=%g", b.Min, b.Max, b.Factor)
+	// Jitter is ignored by the full, equal and none modes
+	if b.Jitter != 0 && (b.Mode == JitterScaled || b.Mode == JitterSymmetric || b.Mode == JitterCentered) {
+		s += fmt.Sprintf(" jitter=%g", b.Jitter)
+	}
+	if b.Mode != JitterScaled {
+		s += " mode=" + b.Mode.String()
+	}
+	if b.MaxSleep != 0 {
+		s += fmt.Sprintf(" max_sleep=%s", b.MaxSleep)
+	}
+	if b.FirstImmediate {
+		s += " first_immediate"
+	}
+	return s
+}
+
+func (d *Decorrelated) String() string {
+	return fmt.Sprintf("decorrelated min=%s max=%s", d.Min, d.Max)
+}
+
+func (w *WeightedInterval) String() string {
+	s := fmt.Sprintf("weighted weight=%g", w.Weight)
+	if w.Max > 0 {
+		s += fmt.Sprintf(" max=%s", w.Max)
+	}
+	if w.Interval != nil {
+		s += fmt.Sprintf(" of (%v)", w.Interval)
+	}
+	return s
+}
+
+func (c Chain) String() string {
+	steps := make([]string, 0, len(c))
+	for _, step := range c {
+		if step.Attempts == 0 {
+			steps = append(steps, fmt.Sprint(step.Interval))
+			continue
+		}
+		steps = append(steps, fmt.Sprintf("%v x%d", step.Interval, step.Attempts))
+	}
+	return "chain [" + strings.Join(steps, ", ") + "]"
+}
+
+func (s Sleep) String() string {
+	return "sleep " + time.Duration(s).String()
+}
+
+func (j JitteredSleep) String() string {
+	return fmt.Sprintf("sleep %s jitter=%g", j.Base, j.Jitter)
+}
+
+func (j JitterInterval) String() string {
+	return fmt.Sprintf("jitter=%g of (%v)", j.Jitter, j.Inner)
+}
+
+func (t *tokenBucket) String() string {
+	return fmt.Sprintf("token bucket rate=%g/s burst=%g", t.rate, t.burst)
+}
+
+func (e *ewmaBudget) String() string {
+	return fmt.Sprintf("ewma ratio=%g half_life=%s%s", e.ratio, e.halfLife, e.ratioBudget.floorString())
+}
+
+func (s *slidingLogBudget) String() string {
+	return fmt.Sprintf("sliding log ratio=%g window=%s%s", s.ratio, s.window, s.ratioBudget.floorString())
+}
+
+// floorString describes the floor when it is not the default
+func (r ratioBudget) floorString() string {
+	if r.floor == 1 {
+		return ""
+	}
+	return fmt.Sprintf(" floor=%g", r.floor)
+}
+
+func (b *batchedBudget) String() string {
+	return fmt.Sprintf("batched interval=%s of (%v)", time.Duration(b.interval), b.budget)
+}
+
+func (l *latencyBudget) String() string {
+	return fmt.Sprintf("latency threshold=%s half_life=%s of (%v)", l.threshold, l.halfLife, l.budget)
+}
+
+func (r *rampBudget) String() string {
+	return fmt.Sprintf("ramp %s of (%v)", r.ramp, r.budget)
+}
+
+// formatAttempts returns the attempts, or ∞ when attempts is 0
+func formatAttempts(n int) string {
+	if n == 0 {
+		return "∞"
+	}
+	return strconv.Itoa(n)
+}
+
+// formatCodes returns the codes as a compact list, such as [429,503]
+func formatCodes(codes []int) string {
+	s := make([]string, len(codes))
+	for i, c := range codes {
+		s[i] = strconv.Itoa(c)
+	}
+	return "[" + strings.Join(s, ",") + "]"
+}
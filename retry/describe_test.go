@@ -0,0 +1,90 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyString(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		policy   retry.Policy
+		expected string
+	}{
+		{
+			name:     "Twice",
+			policy:   retry.Twice,
+			expected: "backoff min=500ms max=5s factor=2 jitter=0.2 attempts=2",
+		},
+		{
+			name: "Codes",
+			policy: retry.Policy{
+				Interval:     retry.BackOff{Min: time.Second, Max: time.Minute, Factor: 1.5, Mode: retry.JitterFull},
+				OnCodes:      []int{429, 503},
+				OnInfraCodes: []int{502},
+			},
+			expected: "backoff min=1s max=1m0s factor=1.5 mode=full attempts=∞ codes=[429,503] infra_codes=[502]",
+		},
+		{
+			name: "Sleep",
+			policy: retry.Policy{
+				Interval: retry.Sleep(5 * time.Second),
+				Attempts: 3,
+				Budget:   retry.NewTokenBucketBudget(10, 20),
+			},
+			expected: "sleep 5s attempts=3 budget=(token bucket rate=10/s burst=20)",
+		},
+		{
+			name: "Composed",
+			policy: retry.Policy{
+				Interval: retry.Chain{
+					{Interval: retry.JitteredSleep{Base: time.Second, Jitter: 0.2}, Attempts: 3},
+					{Interval: retry.JitterInterval{Inner: &retry.Decorrelated{Min: time.Second, Max: time.Minute}, Jitter: 0.1}},
+				},
+				Budget: retry.NewRampBudget(retry.NewBatchedBudget(
+					retry.NewEWMABudget(0.1, 30*time.Second, retry.WithFailureFloor(5)), time.Second), time.Minute, nil),
+			},
+			expected: "chain [sleep 1s jitter=0.2 x3, jitter=0.1 of (decorrelated min=1s max=1m0s)] attempts=∞ " +
+				"budget=(ramp 1m0s of (batched interval=1s of (ewma ratio=0.1 half_life=30s floor=5)))",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.policy.String())
+		})
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		b, err := json.Marshal(retry.Policy{
+			Interval: retry.DefaultBackOff,
+			OnCodes:  []int{429},
+			Budget:   retry.NewSlidingLogBudget(0.5, time.Minute),
+			Attempts: 5,
+		})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+			"interval": "backoff min=500ms max=5s factor=2 jitter=0.2",
+			"attempts": 5,
+			"codes": [429],
+			"budget": "sliding log ratio=0.5 window=1m0s"
+		}`, string(b))
+	})
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Hedge calls the operation and, if it has not returned after 'delay', calls it again in
+// parallel, up to 'maxHedges' additional attempts staggered by 'delay'. The result of the
+// first attempt to succeed is returned and the context passed to every other attempt is
+// cancelled. Hedge does not wait for cancelled attempts to return, so the operation must
+// respect the context to avoid doing wasted work.
+//
+// If an attempt fails while no other attempt is in flight, the next hedge is started
+// immediately instead of waiting for the delay. If every attempt fails, the error from the
+// final attempt is returned. Unlike On(), Hedge reduces tail latency rather than recovering
+// from failure, and so should only be used with idempotent operations. A 'maxHedges' less
+// than 1 makes a single attempt.
+//
+//	resp, err := retry.Hedge(ctx, func(ctx context.Context, attempt int) (*Response, error) {
+//		return client.DoThing(ctx, &req)
+//	}, 50*time.Millisecond, 2)
+func Hedge[T any](ctx context.Context, operation func(context.Context, int) (T, error),
+	delay time.Duration, maxHedges int) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+	var zero T
+	maxHedges = max(maxHedges, 0)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(errors.New("retry: hedge returned"))
+
+	// Buffered such that attempts which lose the race never block
+	results := make(chan result, maxHedges+1)
+	launch := func(attempt int) {
+		go func() {
+			v, err := operation(ctx, attempt)
+			results <- result{value: v, err: err}
+		}()
+	}
+
+	attempt, inflight := 1, 1
+	launch(attempt)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var last error
+	for {
+		var hedge <-chan time.Time
+		if attempt <= maxHedges {
+			hedge = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			if last != nil {
+				return zero, &ContextError{Err: ctx.Err(), Last: last}
+			}
+			return zero, ctx.Err()
+		case <-hedge:
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				cancel(errors.New("retry: another hedged attempt succeeded"))
+				return r.value, nil
+			}
+			last = r.err
+			if inflight > 0 {
+				continue
+			}
+			if attempt > maxHedges {
+				return zero, last
+			}
+		}
+		attempt++
+		inflight++
+		launch(attempt)
+		timer.Reset(delay)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedge(t *testing.T) {
+	t.Run("HedgeWins", func(t *testing.T) {
+		cancelled := make(chan error, 1)
+		start := time.Now()
+		v, err := retry.Hedge(context.Background(), func(ctx context.Context, attempt int) (int, error) {
+			if attempt == 1 {
+				// The first attempt is slow and only returns once cancelled
+				<-ctx.Done()
+				cancelled <- context.Cause(ctx)
+				return 0, ctx.Err()
+			}
+			return attempt, nil
+		}, 10*time.Millisecond, 2)
+		require.NoError(t, err)
+		assert.Equal(t, 2, v)
+		assert.Less(t, time.Since(start), time.Second)
+
+		select {
+		case cause := <-cancelled:
+			assert.EqualError(t, cause, "retry: another hedged attempt succeeded")
+		case <-time.After(time.Second):
+			t.Fatal("losing attempt was not cancelled")
+		}
+	})
+
+	t.Run("NoHedgeWhenFast", func(t *testing.T) {
+		var calls atomic.Int32
+		v, err := retry.Hedge(context.Background(), func(ctx context.Context, attempt int) (string, error) {
+			calls.Add(1)
+			return "fast", nil
+		}, 100*time.Millisecond, 2)
+		require.NoError(t, err)
+		assert.Equal(t, "fast", v)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("MaxHedges", func(t *testing.T) {
+		var calls atomic.Int32
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := retry.Hedge(ctx, func(ctx context.Context, attempt int) (int, error) {
+			calls.Add(1)
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}, time.Millisecond, 2)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("AllFail", func(t *testing.T) {
+		errFail := errors.New("fail")
+		var calls atomic.Int32
+		_, err := retry.Hedge(context.Background(), func(ctx context.Context, attempt int) (int, error) {
+			calls.Add(1)
+			return 0, errFail
+		}, time.Hour, 2)
+		// Failed attempts start the next hedge without waiting for the delay
+		assert.Equal(t, errFail, err)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("NegativeMaxHedges", func(t *testing.T) {
+		errFail := errors.New("fail")
+		var calls atomic.Int32
+		_, err := retry.Hedge(context.Background(), func(ctx context.Context, attempt int) (int, error) {
+			calls.Add(1)
+			return 0, errFail
+		}, time.Millisecond, -5)
+		assert.Equal(t, errFail, err)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"context"
+)
+
+// Limiter caps the number of retry attempts in flight at once across all callers which
+// share it. A Budget limits the rate of retries, but during an outage retries which are
+// within budget can still pile up concurrently and overwhelm a recovering service.
+//
+//	policy := retry.Policy{
+//		Interval: retry.DefaultBackOff,
+//		Limiter:  retry.NewLimiter(10),
+//	}
+//
+// A Limiter is safe for concurrent use and is intended to be shared by all callers of a service.
+type Limiter struct {
+	sem chan struct{}
+}
+
+// NewLimiter returns a Limiter which allows at most 'max' retry attempts in flight at once.
+// A 'max' less than 1 allows a single attempt, as a Limiter which allows none would block
+// every retry forever.
+func NewLimiter(max int) *Limiter {
+	if max < 1 {
+		max = 1
+	}
+	return &Limiter{sem: make(chan struct{}, max)}
+}
+
+// Acquire blocks until a retry attempt may be made or the context is cancelled. Each
+// successful call to Acquire must be followed by a call to Release.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	select {
+	case l.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release ends a retry attempt started by Acquire
+func (l *Limiter) Release() {
+	<-l.sem
+}
+
+// InFlight returns the number of retry attempts currently in flight
+func (l *Limiter) InFlight() int {
+	return len(l.sem)
+}
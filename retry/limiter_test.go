@@ -0,0 +1,102 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := retry.NewLimiter(3)
+	policy := retry.Policy{
+		Interval: retry.Sleep(time.Millisecond),
+		Limiter:  limiter,
+		Attempts: 4,
+	}
+
+	var inflight, peak, retries atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+				if attempt == 1 {
+					return retry.Retryable(errors.New("fail"))
+				}
+				n := inflight.Add(1)
+				defer inflight.Add(-1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				retries.Add(1)
+				time.Sleep(5 * time.Millisecond)
+				if attempt == 2 {
+					return retry.Retryable(errors.New("fail"))
+				}
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(40), retries.Load())
+	assert.LessOrEqual(t, peak.Load(), int32(3))
+	assert.Greater(t, peak.Load(), int32(0))
+	assert.Equal(t, 0, limiter.InFlight())
+
+	t.Run("ContextCancelled", func(t *testing.T) {
+		limiter := retry.NewLimiter(1)
+		require.NoError(t, limiter.Acquire(context.Background()))
+		defer limiter.Release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		errFail := errors.New("fail")
+		var attempts int
+		err := retry.On(ctx, retry.Policy{Interval: retry.Sleep(time.Millisecond), Limiter: limiter},
+			func(ctx context.Context, attempt int) error {
+				attempts++
+				return retry.Retryable(errFail)
+			})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorIs(t, err, errFail)
+		// The first attempt is never limited, the retry waits for the limiter
+		assert.Equal(t, 1, attempts)
+	})
+	t.Run("InvalidMax", func(t *testing.T) {
+		for _, max := range []int{0, -1} {
+			limiter := retry.NewLimiter(max)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			require.NoError(t, limiter.Acquire(ctx))
+			cancel()
+			assert.Equal(t, 1, limiter.InFlight())
+			limiter.Release()
+		}
+	})
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+// Metrics collects observability counters from On() and Retrier. Implementations must be
+// safe for concurrent use when the Policy is shared across goroutines.
+//
+// The methods are called in the following order for each retry loop
+//
+//   - IncAttempt() is called before each attempt of the operation, including the first
+//   - IncRetry() is called before sleeping for the next attempt. The attempt may never be
+//     made if the sleep is cancelled or the budget, breaker or limiter stops the loop, so
+//     IncRetry() can equal IncAttempt(); it never exceeds it
+//   - IncBudgetBlocked() is called for each sleep made while Policy.Budget is over
+//   - ObserveOutcome() is called exactly once after the final attempt, or when the loop is
+//     cancelled
+type Metrics interface {
+	IncAttempt()
+	IncRetry()
+	IncBudgetBlocked()
+	ObserveOutcome(success bool)
+}
+
+type noOpMetrics struct{}
+
+func (noOpMetrics) IncAttempt()         {}
+func (noOpMetrics) IncRetry()           {}
+func (noOpMetrics) IncBudgetBlocked()   {}
+func (noOpMetrics) ObserveOutcome(bool) {}
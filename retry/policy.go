@@ -0,0 +1,123 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PolicyOption configures a Policy created by NewPolicy()
+type PolicyOption func(*Policy)
+
+// NewPolicy returns a validated Policy configured by the provided options. The Interval defaults
+// to DefaultBackOff and Attempts defaults to 0 (retry until success or the context is cancelled).
+// See Policy.Validate() for the rules applied.
+//
+//	policy, err := retry.NewPolicy(
+//		retry.WithBackOff(500*time.Millisecond, 5*time.Second, 2, 0.2),
+//		retry.WithCodes(duh.RetryableCodes...),
+//		retry.WithAttempts(5),
+//	)
+func NewPolicy(opts ...PolicyOption) (Policy, error) {
+	p := Policy{Interval: DefaultBackOff}
+	for _, opt := range opts {
+		opt(&p)
+	}
+	if err := p.Validate(); err != nil {
+		return Policy{}, err
+	}
+	return p, nil
+}
+
+// MustPolicy returns the provided Policy unchanged if it passes Policy.Validate(), otherwise it
+// panics. It is intended for package level variables and init code, where a misconfigured
+// Policy is a programmer error which should fail at startup rather than misbehave at runtime.
+//
+//	var policy = retry.MustPolicy(retry.Policy{
+//		Interval: retry.BackOff{Min: time.Second, Max: time.Minute, Factor: 2, Jitter: 0.2},
+//		Attempts: 5,
+//	})
+func MustPolicy(p Policy) Policy {
+	if err := p.Validate(); err != nil {
+		panic(fmt.Sprintf("retry: invalid Policy: %s", err))
+	}
+	return p
+}
+
+// WithBackOff sets the Interval to a BackOff with the provided values
+func WithBackOff(min, max time.Duration, factor, jitter float64) PolicyOption {
+	return func(p *Policy) {
+		p.Interval = BackOff{
+			Min:    min,
+			Max:    max,
+			Factor: factor,
+			Jitter: jitter,
+		}
+	}
+}
+
+// WithInterval sets the Interval
+func WithInterval(i Interval) PolicyOption {
+	return func(p *Policy) {
+		p.Interval = i
+	}
+}
+
+// WithAttempts sets the total number of attempts, 0 for infinite
+func WithAttempts(n int) PolicyOption {
+	return func(p *Policy) {
+		p.Attempts = n
+	}
+}
+
+// WithBudget sets the retry Budget
+func WithBudget(b Budget) PolicyOption {
+	return func(p *Policy) {
+		p.Budget = b
+	}
+}
+
+// WithCodes sets the service response codes which are retried
+func WithCodes(codes ...int) PolicyOption {
+	return func(p *Policy) {
+		p.OnCodes = codes
+	}
+}
+
+// WithInfraCodes sets the infrastructure response codes which are retried
+func WithInfraCodes(codes ...int) PolicyOption {
+	return func(p *Policy) {
+		p.OnInfraCodes = codes
+	}
+}
+
+// Validate returns an error if the Policy is misconfigured. The Interval must not be nil and
+// Attempts must not be negative. If the Interval is a BackOff, it must pass BackOff.Validate().
+func (p Policy) Validate() error {
+	if p.Interval == nil {
+		return errors.New("Policy.Interval cannot be nil")
+	}
+	if p.Attempts < 0 {
+		return fmt.Errorf("Policy.Attempts must not be negative; got '%d'", p.Attempts)
+	}
+	if b, ok := p.Interval.(BackOff); ok {
+		if err := b.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
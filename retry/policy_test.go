@@ -0,0 +1,118 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry_test
+
+import (
+	"testing"
+	"time"
+
+	duh "github.com/duh-rpc/duh.go/v2"
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPolicy(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		p, err := retry.NewPolicy()
+		require.NoError(t, err)
+		assert.Equal(t, retry.DefaultBackOff, p.Interval)
+		assert.Equal(t, 0, p.Attempts)
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		budget := retry.NewTokenBucketBudget(1, 1)
+		p, err := retry.NewPolicy(
+			retry.WithBackOff(time.Millisecond, time.Second, 2, 0.2),
+			retry.WithAttempts(5),
+			retry.WithBudget(budget),
+			retry.WithCodes(duh.CodeTooManyRequests),
+			retry.WithInfraCodes(503),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, retry.BackOff{
+			Min:    time.Millisecond,
+			Max:    time.Second,
+			Factor: 2,
+			Jitter: 0.2,
+		}, p.Interval)
+		assert.Equal(t, 5, p.Attempts)
+		assert.Equal(t, budget, p.Budget)
+		assert.Equal(t, []int{duh.CodeTooManyRequests}, p.OnCodes)
+		assert.Equal(t, []int{503}, p.OnInfraCodes)
+	})
+
+	for _, tt := range []struct {
+		name string
+		opts []retry.PolicyOption
+		err  string
+	}{
+		{
+			name: "NilInterval",
+			opts: []retry.PolicyOption{retry.WithInterval(nil)},
+			err:  "Policy.Interval cannot be nil",
+		},
+		{
+			name: "NegativeAttempts",
+			opts: []retry.PolicyOption{retry.WithAttempts(-1)},
+			err:  "Policy.Attempts must not be negative; got '-1'",
+		},
+		{
+			name: "InvalidBackOff",
+			opts: []retry.PolicyOption{retry.WithBackOff(time.Millisecond, time.Second, 0.5, 0.2)},
+			err:  "BackOff.Factor must be 1.0 or higher; got '0.5'",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := retry.NewPolicy(tt.opts...)
+			require.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestMustPolicy(t *testing.T) {
+	valid := retry.Policy{
+		Interval: retry.BackOff{Min: time.Millisecond, Max: time.Second, Factor: 2, Jitter: 0.2},
+		Attempts: 5,
+		OnCodes:  []int{duh.CodeTooManyRequests},
+	}
+	assert.Equal(t, valid, retry.MustPolicy(valid))
+
+	for _, tt := range []struct {
+		name   string
+		policy retry.Policy
+		panic  string
+	}{
+		{
+			name:   "MaxLessThanMin",
+			policy: retry.Policy{Interval: retry.BackOff{Min: time.Second, Max: time.Millisecond, Factor: 2}},
+			panic:  "retry: invalid Policy: BackOff.Max '1ms' must not be less than BackOff.Min '1s'",
+		},
+		{
+			name:   "JitterAboveOne",
+			policy: retry.Policy{Interval: retry.BackOff{Min: time.Millisecond, Max: time.Second, Factor: 2, Jitter: 1.5}},
+			panic:  "retry: invalid Policy: BackOff.Jitter must be between 0 and 1; got '1.5'",
+		},
+		{
+			name:   "NilInterval",
+			policy: retry.Policy{},
+			panic:  "retry: invalid Policy: Policy.Interval cannot be nil",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.PanicsWithValue(t, tt.panic, func() { retry.MustPolicy(tt.policy) })
+		})
+	}
+}
@@ -0,0 +1,384 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Retrier is a stateful iterator over the attempts allowed by a Policy. It is useful
+// when the retry logic spans multiple functions, or must be interleaved with other work
+// such that the closure form of On() is awkward.
+//
+//	r := retry.NewRetrier(policy)
+//	for {
+//		attempt, ok := r.Next(ctx)
+//		if !ok {
+//			break
+//		}
+//		r.Record(client.DoThing(ctx, attempt))
+//	}
+//	if err := r.Err(); err != nil {
+//		return err
+//	}
+//
+// Policy.AttemptTimeout is not applied by the Retrier, as the Retrier does not call the
+// operation. A Retrier is not safe for concurrent use.
+type Retrier struct {
+	p       Policy
+	clock   Clock
+	metrics Metrics
+	attempt int
+	// executed is the number of attempts returned by Next(), which excludes an attempt
+	// which was never made because the budget, breaker or context stopped it
+	executed int
+	blocked  int
+	slept    time.Duration
+	start    time.Time
+	// last is the error recorded for the most recent attempt
+	last     error
+	errs     []error
+	err      error
+	done     bool
+	observed bool
+	// limited is true while the current attempt holds a slot on Policy.Limiter
+	limited bool
+	// once is true when the operation must not be retried, see WithIdempotent()
+	once bool
+	// attemptStart is the time the current attempt was returned by Next()
+	attemptStart time.Time
+}
+
+// NewRetrier returns a Retrier which retries according to the provided Policy
+func NewRetrier(p Policy) *Retrier {
+	if p.Interval == nil {
+		panic("Policy.Interval cannot be nil")
+	}
+	clock := p.Clock
+	if clock == nil {
+		clock = RealClock
+	}
+	metrics := p.Metrics
+	if metrics == nil {
+		metrics = noOpMetrics{}
+	}
+	// Each loop gets its own instance of a stateful interval, such that concurrent loops
+	// which share a Policy do not share its state
+	p.Interval = cloneInterval(p.Interval)
+	return &Retrier{p: p, clock: clock, metrics: metrics}
+}
+
+// Next blocks until the next attempt should be made and returns the attempt number, starting
+// at 1. The first attempt is returned immediately. Next returns false when the last recorded
+// attempt succeeded, the policy will not retry the last recorded error, the attempts are
+// exhausted or the context is cancelled. Call Err() to retrieve the error once Next returns false.
+// An attempts override set on the context by WithMaxAttempts() takes precedence over Policy.Attempts.
+func (r *Retrier) Next(ctx context.Context) (int, bool) {
+	if r.attempt == 0 {
+		if err := ctx.Err(); err != nil {
+			return r.stop(err)
+		}
+		if n, ok := maxAttempts(ctx); ok {
+			r.p.Attempts = n
+		}
+		if key, ok := budgetKeyFrom(ctx); ok && r.p.BudgetGroup != nil {
+			r.p.Budget = r.p.BudgetGroup.Get(key)
+		}
+		idempotent, ok := idempotentFrom(ctx)
+		r.once = (ok && !idempotent) || (!ok && r.p.RequireIdempotent)
+		if i, ok := r.p.Interval.(Resetter); ok {
+			i.Reset()
+		}
+		r.attempt = 1
+		r.start = r.clock.Now()
+		if r.p.BudgetFirstAttempt {
+			if err := r.waitBudget(ctx); err != nil {
+				return r.stop(err)
+			}
+		}
+		if r.p.Breaker != nil && !r.p.Breaker.Allow() {
+			return r.stop(ErrCircuitOpen)
+		}
+		r.executed++
+		r.metrics.IncAttempt()
+		r.attemptStart = r.clock.Now()
+		return r.attempt, true
+	}
+
+	if r.done || r.last == nil {
+		return r.attempt, false
+	}
+
+	sleepDur := rateLimitDuration(r.last)
+	if sleepDur == 0 {
+		sleepDur = r.p.Interval.Next(r.attempt)
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(r.clock.Now()) <= sleepDur {
+		// The context would expire before the next attempt, so stop now instead of sleeping
+		// until the deadline; the error still wraps the last operation error.
+		return r.stop(context.DeadlineExceeded)
+	}
+	if r.draining() {
+		return r.stop(ErrDraining)
+	}
+	r.metrics.IncRetry()
+	if r.p.OnRetry != nil {
+		r.p.OnRetry(r.attempt, r.last, sleepDur)
+	}
+	if err := r.sleep(ctx, sleepDur, r.p.Wake); err != nil {
+		return r.stop(err)
+	}
+	r.attempt++
+
+	if err := r.waitBudget(ctx); err != nil {
+		return r.stop(err)
+	}
+	if err := ctx.Err(); err != nil {
+		return r.stop(err)
+	}
+	if r.p.Limiter != nil {
+		if err := r.p.Limiter.Acquire(ctx); err != nil {
+			return r.stop(err)
+		}
+		r.limited = true
+	}
+	if r.p.Breaker != nil && !r.p.Breaker.Allow() {
+		r.release()
+		return r.stop(ErrCircuitOpen)
+	}
+	r.executed++
+	r.metrics.IncAttempt()
+	r.attemptStart = r.clock.Now()
+	return r.attempt, true
+}
+
+// waitBudget sleeps without making an attempt while the budget is over, using the
+// interval for the current attempt. Returns ErrBudgetExhausted if Policy.BudgetWaitTimeout
+// elapses before the budget recovers, or immediately if Policy.BudgetOver is BudgetFailFast.
+func (r *Retrier) waitBudget(ctx context.Context) error {
+	start := r.clock.Now()
+	for r.p.Budget != nil && r.p.Budget.IsOver(r.clock.Now()) {
+		if r.p.BudgetOver == BudgetFailFast {
+			return ErrBudgetExhausted
+		}
+		d := r.p.Interval.Next(r.attempt)
+		if r.p.BudgetWaitTimeout > 0 {
+			remaining := r.p.BudgetWaitTimeout - r.clock.Now().Sub(start)
+			if remaining <= 0 {
+				return ErrBudgetExhausted
+			}
+			d = min(d, remaining)
+		}
+		r.metrics.IncBudgetBlocked()
+		r.blocked++
+		// Wake is not consulted while the budget is over, a closed Wake channel would
+		// otherwise turn this loop into a busy spin until the budget recovers.
+		if err := r.sleep(ctx, d, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sleep sleeps for 'd' or until 'wake' is signalled, adding the time slept to the stats
+func (r *Retrier) sleep(ctx context.Context, d time.Duration, wake <-chan struct{}) error {
+	start := r.clock.Now()
+	woken, err := sleep(ctx, r.clock, d, wake, r.p.Drain)
+	if err != nil {
+		return err
+	}
+	if woken {
+		d = r.clock.Now().Sub(start)
+	}
+	r.slept += d
+	return nil
+}
+
+// draining returns true if Policy.Drain has been closed
+func (r *Retrier) draining() bool {
+	select {
+	case <-r.p.Drain:
+		return true
+	default:
+		return false
+	}
+}
+
+// Record records the result of the current attempt, and must be called after each attempt
+// returned by Next().
+func (r *Retrier) Record(err error) {
+	r.record(err, false)
+}
+
+// Blocked returns the number of sleeps made while the budget was over. Blocked sleeps do not
+// increase the attempt number, so the back off after the budget recovers is not inflated by
+// the time spent waiting for the budget.
+func (r *Retrier) Blocked() int {
+	return r.blocked
+}
+
+// Stats returns the statistics of the retry loop so far
+func (r *Retrier) Stats() Stats {
+	s := Stats{Attempts: r.executed, Blocked: r.blocked, TotalSleep: r.slept}
+	if !r.start.IsZero() {
+		s.Elapsed = r.clock.Now().Sub(r.start)
+	}
+	return s
+}
+
+// Err returns the error which caused Next() to return false, or nil if the last attempt
+// succeeded.
+func (r *Retrier) Err() error {
+	return r.err
+}
+
+func (r *Retrier) record(err error, timedOut bool) {
+	r.release()
+	if r.p.OnAttempt != nil {
+		r.p.OnAttempt(r.attempt, err)
+	}
+	r.last = err
+	if lo, ok := r.p.Budget.(LatencyObserver); ok {
+		now := r.clock.Now()
+		lo.ObserveLatency(now, now.Sub(r.attemptStart))
+	}
+	if err == nil {
+		if r.p.Budget != nil && (r.attempt > 1 || !r.p.BudgetRetrySuccessOnly) {
+			r.p.Budget.Success(r.clock.Now(), 1)
+		}
+		if r.p.Breaker != nil {
+			r.p.Breaker.Success()
+		}
+		r.err = nil
+		r.observe(true)
+		return
+	}
+
+	var pe *permanentError
+	permanent := errors.As(err, &pe)
+	if permanent {
+		err = pe.err
+		r.last = err
+	}
+	r.err = err
+	if r.p.CollectErrors {
+		r.errs = append(r.errs, fmt.Errorf("attempt %d: %w", r.attempt, err))
+		r.err = errors.Join(r.errs...)
+	}
+
+	// Only errors which are retryable count against the budget and breaker, a non-retryable
+	// error will not be retried and so should not starve other callers of retries.
+	retryable := !permanent && (shouldRetry(err, r.p, r.attempt) || (timedOut && r.p.ShouldRetry == nil))
+	// An operation which must not be retried never consumes the budget, but a retryable
+	// error is still reported to the breaker as the service is unhealthy.
+	if retryable && !r.once && r.p.Budget != nil {
+		hits := 1
+		if r.p.BudgetWeightByAttempt {
+			hits = r.attempt
+		}
+		if wb, ok := r.p.Budget.(WeightedBudget); ok && r.p.BudgetWeight != nil {
+			wb.FailureWeighted(r.clock.Now(), r.p.BudgetWeight(err)*float64(hits))
+		} else {
+			r.p.Budget.Failure(r.clock.Now(), hits)
+		}
+	}
+	if r.p.Breaker != nil {
+		// A non-retryable error indicates the service responded, and so is reported to
+		// the breaker as a success. This also ensures a half-open probe is always resolved.
+		if retryable {
+			r.p.Breaker.Failure()
+		} else {
+			r.p.Breaker.Success()
+		}
+	}
+	if !retryable || r.once {
+		r.done = true
+		r.observe(false)
+		return
+	}
+	if r.p.Attempts != 0 && r.attempt >= r.p.Attempts {
+		r.err = &RetryError{
+			Attempts: r.attempt,
+			Elapsed:  r.clock.Now().Sub(r.start),
+			Err:      r.err,
+		}
+		r.done = true
+		r.observe(false)
+		return
+	}
+	// Give up now instead of after the next sleep, such that the cause of the attempt
+	// context reports the budget stopped the loop.
+	if r.p.BudgetOver == BudgetFailFast && r.p.Budget != nil && r.p.Budget.IsOver(r.clock.Now()) {
+		r.err = &BudgetExhaustedError{Last: r.err}
+		r.done = true
+		r.observe(false)
+	}
+}
+
+func (r *Retrier) stop(err error) (int, bool) {
+	r.done = true
+	if errors.Is(err, ErrDraining) {
+		// Return the error from the last attempt, which the caller is more interested in
+		// than the reason the loop stopped while shutting down.
+		if r.last == nil {
+			r.err = err
+		}
+		r.observe(false)
+		return r.attempt, false
+	}
+	if r.last != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		err = &ContextError{Err: err, Last: r.err}
+	}
+	if r.last != nil && errors.Is(err, ErrCircuitOpen) {
+		err = &CircuitOpenError{Last: r.err}
+	}
+	if r.last != nil && errors.Is(err, ErrBudgetExhausted) {
+		err = &BudgetExhaustedError{Last: r.err}
+	}
+	r.err = err
+	r.observe(false)
+	return r.attempt, false
+}
+
+// attemptCause returns the reason the current attempt, which returned 'err', has ended
+func (r *Retrier) attemptCause(err error) error {
+	if err == nil {
+		return fmt.Errorf("retry: attempt %d succeeded", r.attempt)
+	}
+	if r.done {
+		return fmt.Errorf("retry: gave up: %w", r.err)
+	}
+	return fmt.Errorf("retry: attempt %d failed: %w", r.attempt, err)
+}
+
+// release returns the slot held on Policy.Limiter by the current attempt, if any
+func (r *Retrier) release() {
+	if r.limited {
+		r.p.Limiter.Release()
+		r.limited = false
+	}
+}
+
+// observe reports the outcome to Metrics, only the first outcome is reported
+func (r *Retrier) observe(success bool) {
+	if r.observed {
+		return
+	}
+	r.observed = true
+	r.metrics.ObserveOutcome(success)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	duh "github.com/duh-rpc/duh.go/v2"
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetrier(t *testing.T) {
+	errFail := errors.New("fail")
+	policy := retry.Policy{
+		Interval: retry.Sleep(time.Millisecond),
+		Attempts: 5,
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		r := retry.NewRetrier(policy)
+		var attempts []int
+		for {
+			attempt, ok := r.Next(context.Background())
+			if !ok {
+				break
+			}
+			attempts = append(attempts, attempt)
+			if attempt < 3 {
+				r.Record(errFail)
+				continue
+			}
+			r.Record(nil)
+		}
+		require.NoError(t, r.Err())
+		assert.Equal(t, []int{1, 2, 3}, attempts)
+	})
+
+	t.Run("AttemptsExhausted", func(t *testing.T) {
+		r := retry.NewRetrier(policy)
+		var count int
+		for {
+			if _, ok := r.Next(context.Background()); !ok {
+				break
+			}
+			count++
+			r.Record(errFail)
+		}
+		require.ErrorIs(t, r.Err(), errFail)
+		assert.Equal(t, 5, count)
+	})
+
+	t.Run("NonRetryable", func(t *testing.T) {
+		r := retry.NewRetrier(retry.Policy{
+			OnCodes:  []int{duh.CodeTooManyRequests},
+			Interval: retry.Sleep(time.Millisecond),
+			Attempts: 5,
+		})
+		var count int
+		for {
+			if _, ok := r.Next(context.Background()); !ok {
+				break
+			}
+			count++
+			r.Record(&testError{code: "400", httpCode: duh.CodeBadRequest})
+		}
+		require.Error(t, r.Err())
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("ContextCancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		r := retry.NewRetrier(retry.Policy{
+			Interval: retry.Sleep(time.Minute),
+		})
+		_, ok := r.Next(ctx)
+		require.True(t, ok)
+		r.Record(errFail)
+
+		time.AfterFunc(10*time.Millisecond, cancel)
+		_, ok = r.Next(ctx)
+		assert.False(t, ok)
+		assert.ErrorIs(t, r.Err(), context.Canceled)
+	})
+}
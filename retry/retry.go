@@ -17,10 +17,12 @@ package retry
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math"
 	"math/rand"
 	"slices"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -36,6 +38,12 @@ type infraChecker interface {
 	IsInfraError() bool
 }
 
+// retryAfterer is satisfied by errors which suggest how long to wait before retrying.
+// Errors which do not originate from duh can implement this to provide a hint to On().
+type retryAfterer interface {
+	RetryAfter() time.Duration
+}
+
 // detailer is satisfied by errors that carry a details map.
 // duh.Error satisfies this via Details() map[string]string.
 type detailer interface {
@@ -51,30 +59,344 @@ type Interval interface {
 	Next(attempts int) time.Duration
 }
 
+// Resetter is implemented by a stateful Interval such as Decorrelated. On() and Retrier call
+// Reset() before the first attempt, such that a Policy reused across independent retry loops
+// starts each loop fresh instead of carrying over the state of the previous loop.
+//
+// Reset() is called on the Interval held by the Policy, so a stateful Interval shared by loops
+// which run concurrently is reset by one loop while another is mid-loop, and the loops share
+// a single sequence. A stateful Interval which may be shared should also implement Cloner.
+type Resetter interface {
+	// Reset returns the interval to its initial state
+	Reset()
+}
+
+// Cloner is implemented by a stateful Interval which can give each retry loop its own
+// instance. On() and Retrier call Clone() before the first attempt, such that concurrent
+// loops which share a Policy do not share the state of the Interval.
+type Cloner interface {
+	// Clone returns a copy of the interval in its initial state
+	Clone() Interval
+}
+
+// Random is a source of random values used to apply jitter. *rand.Rand satisfies this
+// interface, but is not safe for concurrent use. Use SafeRand() if the source will be shared.
+type Random interface {
+	// Float64 returns a pseudo-random number in [0.0,1.0)
+	Float64() float64
+}
+
+type safeRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (s *safeRand) Float64() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.Float64()
+}
+
+// SafeRand returns a Random which is safe for concurrent use, seeded with the provided seed.
+// This is useful when a deterministic jitter sequence is desired.
+func SafeRand(seed int64) Random {
+	return &safeRand{r: rand.New(rand.NewSource(seed))}
+}
+
+// JitterMode selects the algorithm BackOff uses to randomize the computed back off.
+type JitterMode int
+
+const (
+	// JitterScaled scales the back off by a random value in [0, Jitter). This is the default.
+	JitterScaled JitterMode = iota
+	// JitterSymmetric picks a random value in [d - Jitter*d, d + Jitter*d].
+	JitterSymmetric
+	// JitterFull picks a random value in [0, d] where d is the back off capped at Max.
+	// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+	JitterFull
+	// JitterEqual picks a random value in [d/2, d] where d is the back off capped at Max.
+	JitterEqual
+	// JitterNone disables jitter regardless of the value of Jitter.
+	JitterNone
+	// JitterCentered picks a random value in [d - Jitter*d, d + Jitter*d] where d is the back
+	// off capped at Max. Unlike the other modes, the result is not clamped to Max, so once the
+	// back off reaches Max the mean sleep stays at Max instead of being biased below it. The
+	// largest possible sleep is Max + Jitter*Max.
+	JitterCentered
+)
+
 type BackOff struct {
-	Min    time.Duration
-	Max    time.Duration
+	Min time.Duration
+	// Max caps the back off before jitter is applied. It is the ceiling on every sleep for
+	// every mode except JitterCentered, which may sleep up to Max + Jitter*Max such that the
+	// mean sleep remains Max. Set MaxSleep for a ceiling which holds in every mode.
+	Max time.Duration
+	// Factor is the multiplier applied to the back off for each attempt. A Factor of exactly
+	// 1.0 does not grow the back off, every attempt sleeps Min with jitter applied; this is
+	// a constant interval equivalent to Sleep(Min).
 	Factor float64
 	Jitter float64
-	Rand   *rand.Rand
+	// Rand is the source of randomness used for jitter, defaults to the math/rand top level
+	// functions. BackOff may be shared by many goroutines, so Rand must be safe for concurrent
+	// use; see SafeRand().
+	Rand Random
+	// Mode selects the jitter algorithm, defaults to JitterScaled. The result is clamped to
+	// [Min, Max], or [Min, Max + Jitter*Max] for JitterCentered, so no mode will sleep less
+	// than Min.
+	Mode JitterMode
+	// FirstImmediate when true makes the first retry without sleeping. Subsequent retries back
+	// off from Min as if the immediate retry had not happened, such that the second retry
+	// sleeps what the first would have. Min must still be greater than zero, as it is the base
+	// from which the back off grows; a Min of zero would sleep zero on every attempt.
+	FirstImmediate bool
+	// Sample when not nil picks the jittered back off from the range [lower, upper] selected
+	// by Mode, such that a distribution other than uniform can shape the recovery curve. The
+	// default samples uniformly using Rand. The result is clamped to [Min, Max] as usual.
+	//
+	//	// Favor shorter sleeps by squaring a uniform sample
+	//	b.Sample = func(lower, upper float64) float64 {
+	//		r := rand.Float64()
+	//		return lower + (r * r * (upper - lower))
+	//	}
+	Sample func(lower, upper float64) float64
+	// MaxSleep when non zero is a hard ceiling on every sleep after jitter is applied. Max
+	// caps the back off, but JitterCentered may sleep up to Max + Jitter*Max so the mean
+	// sleep remains Max; MaxSleep caps the result regardless of the jitter mode.
+	MaxSleep time.Duration
+}
+
+// NewBackOff returns a validated BackOff with the default jitter mode. See BackOff.Validate()
+// for the rules applied.
+func NewBackOff(min, max time.Duration, factor, jitter float64) (BackOff, error) {
+	b := BackOff{
+		Min:    min,
+		Max:    max,
+		Factor: factor,
+		Jitter: jitter,
+	}
+	if err := b.Validate(); err != nil {
+		return BackOff{}, err
+	}
+	return b, nil
+}
+
+// DeterministicBackOff returns a BackOff without jitter, such that Next() always returns the
+// same sequence of sleeps. This is useful when writing golden tests against a back off sequence.
+func DeterministicBackOff(min, max time.Duration, factor float64) BackOff {
+	return BackOff{
+		Min:    min,
+		Max:    max,
+		Factor: factor,
+		Mode:   JitterNone,
+	}
+}
+
+// Validate returns an error if the BackOff is misconfigured. Min must be greater than zero,
+// Max must not be less than Min, Factor must be 1.0 or higher and Jitter must be between 0 and 1.
+// Use FirstImmediate instead of a zero Min for a back off which retries immediately first.
+// A Factor of exactly 1.0 is valid and results in a constant interval of Min.
+func (b BackOff) Validate() error {
+	if b.Min <= 0 {
+		return fmt.Errorf("BackOff.Min must be greater than zero; got '%s'", b.Min)
+	}
+	if b.Max < b.Min {
+		return fmt.Errorf("BackOff.Max '%s' must not be less than BackOff.Min '%s'", b.Max, b.Min)
+	}
+	if b.Factor < 1.0 {
+		return fmt.Errorf("BackOff.Factor must be 1.0 or higher; got '%v'", b.Factor)
+	}
+	if b.Jitter < 0 || b.Jitter > 1 {
+		return fmt.Errorf("BackOff.Jitter must be between 0 and 1; got '%v'", b.Jitter)
+	}
+	if b.MaxSleep != 0 && b.MaxSleep < b.Min {
+		return fmt.Errorf("BackOff.MaxSleep '%s' must not be less than BackOff.Min '%s'", b.MaxSleep, b.Min)
+	}
+	return nil
 }
 
 func (b BackOff) Next(attempts int) time.Duration {
-	d := time.Duration(float64(b.Min) * math.Pow(b.Factor, float64(attempts)))
-	if b.Jitter > 0 {
-		r := rand.Float64()
-		if b.Rand != nil {
-			r = b.Rand.Float64()
+	attempts, immediate := b.immediate(attempts)
+	if immediate {
+		return 0
+	}
+	// Jitter and clamping is calculated using float64 such that a large number of attempts
+	// cannot overflow time.Duration before the result is clamped to Max.
+	d := b.backoff(attempts)
+	lower, upper := b.jitterRange(d)
+	// A misconfigured Jitter above 1 would otherwise pick from a range which extends below
+	// zero, which the clamp to Min does not catch when Min is zero.
+	lower = max(lower, 0)
+	d = lower
+	if upper > lower {
+		d = b.sample(lower, upper)
+	}
+	return b.clamp(d)
+}
+
+// BackOffExplain describes how BackOff calculates the sleep for an attempt. When encoded
+// as JSON, durations are integer nanoseconds such that tooling can consume Chart() output
+// without parsing time.Duration.String().
+type BackOffExplain struct {
+	// Attempt is the attempt number explained
+	Attempt int `json:"attempt"`
+	// BackOff is Min * Factor^Attempt before jitter and clamping is applied
+	BackOff time.Duration `json:"backoff_ns"`
+	// Clamped is BackOff clamped to [Min, Max], the value Next() returns when no jitter is
+	// applied. Unlike BackOff, it reflects the cap on large attempts where BackOff exceeds Max.
+	Clamped time.Duration `json:"clamped_ns"`
+	// WithJitter is a sample of the value returned by Next() for this attempt
+	WithJitter time.Duration `json:"with_jitter_ns"`
+	// RangeMin is the smallest value Next() can return for this attempt
+	RangeMin time.Duration `json:"range_min_ns"`
+	// RangeMax is the largest value Next() can return for this attempt
+	RangeMax time.Duration `json:"range_max_ns"`
+	// Constant is true when Factor is 1.0, such that the back off never grows beyond Min
+	Constant bool `json:"constant,omitempty"`
+}
+
+// String returns a human readable description of the explanation, noting when the attempt
+// has no jitter applied instead of printing an empty range.
+func (e BackOffExplain) String() string {
+	var s string
+	if e.RangeMin == e.RangeMax {
+		s = fmt.Sprintf("attempt %d: backoff %s, sleep %s (no jitter)", e.Attempt, e.BackOff, e.WithJitter)
+	} else {
+		s = fmt.Sprintf("attempt %d: backoff %s, sleep %s (jitter range %s - %s)",
+			e.Attempt, e.BackOff, e.WithJitter, e.RangeMin, e.RangeMax)
+	}
+	if e.Constant {
+		s += " (constant interval)"
+	}
+	return s
+}
+
+// Explain returns a description of how the sleep for the provided attempt is calculated
+func (b BackOff) Explain(attempt int) BackOffExplain {
+	n, immediate := b.immediate(attempt)
+	if immediate {
+		return BackOffExplain{Attempt: attempt}
+	}
+	d := b.backoff(n)
+	lower, upper := b.jitterRange(d)
+	return BackOffExplain{
+		Attempt:    attempt,
+		BackOff:    toDuration(d),
+		Clamped:    b.clamp(d),
+		WithJitter: b.Next(attempt),
+		RangeMin:   b.clamp(lower),
+		RangeMax:   b.clamp(upper),
+		Constant:   b.Factor == 1.0,
+	}
+}
+
+// Chart returns an explanation for each attempt from 1 through 'attempts', which is useful
+// when plotting or comparing back off configurations.
+func (b BackOff) Chart(attempts int) []BackOffExplain {
+	chart := make([]BackOffExplain, 0, attempts)
+	for i := 1; i <= attempts; i++ {
+		chart = append(chart, b.Explain(i))
+	}
+	return chart
+}
+
+// Schedule returns the sleep for each attempt from 1 through 'attempts' without jitter and
+// clamped to [Min, Max], such that callers can compute the schedule once and index into it
+// instead of calling Next() for each attempt.
+func (b BackOff) Schedule(attempts int) []time.Duration {
+	schedule := make([]time.Duration, 0, attempts)
+	for i := 1; i <= attempts; i++ {
+		n, immediate := b.immediate(i)
+		if immediate {
+			schedule = append(schedule, 0)
+			continue
+		}
+		schedule = append(schedule, b.clamp(b.backoff(n)))
+	}
+	return schedule
+}
+
+// immediate returns true if the attempt is the immediate first retry of FirstImmediate,
+// otherwise it returns the attempt used to calculate the back off
+func (b BackOff) immediate(attempt int) (int, bool) {
+	if !b.FirstImmediate {
+		return attempt, false
+	}
+	if attempt <= 1 {
+		return attempt, true
+	}
+	return attempt - 1, false
+}
+
+// jitterRange returns the range of values from which the jitter mode picks a random value
+func (b BackOff) jitterRange(d float64) (float64, float64) {
+	switch b.Mode {
+	case JitterScaled:
+		if b.Jitter > 0 {
+			return 0, b.Jitter * d
+		}
+	case JitterSymmetric:
+		if b.Jitter > 0 {
+			return d - (b.Jitter * d), d + (b.Jitter * d)
 		}
-		d = time.Duration(r * b.Jitter * float64(d))
+	case JitterFull:
+		return 0, min(d, float64(b.Max))
+	case JitterEqual:
+		d = min(d, float64(b.Max))
+		return d / 2, d
+	case JitterCentered:
+		d = min(d, float64(b.Max))
+		return d - (b.Jitter * d), d + (b.Jitter * d)
+	}
+	return d, d
+}
+
+// clamp returns the duration clamped to [Min, Max], or [Min, Max + Jitter*Max] for JitterCentered.
+// MaxSleep when set is the ceiling regardless of the mode.
+func (b BackOff) clamp(d float64) time.Duration {
+	ceiling := float64(b.Max)
+	if b.Mode == JitterCentered {
+		ceiling += b.Jitter * float64(b.Max)
+	}
+	if b.MaxSleep > 0 {
+		ceiling = min(ceiling, float64(b.MaxSleep))
 	}
-	if d > b.Max {
-		return b.Max
+	if d > ceiling {
+		return time.Duration(ceiling)
 	}
-	if d < b.Min {
+	if d < float64(b.Min) {
 		return b.Min
 	}
-	return d
+	return time.Duration(d)
+}
+
+// backoff returns Min * Factor^attempts without jitter, capped to math.MaxInt64
+func (b BackOff) backoff(attempts int) float64 {
+	d := float64(b.Min) * math.Pow(b.Factor, float64(attempts))
+	if math.IsNaN(d) {
+		return float64(b.Min)
+	}
+	return min(d, math.MaxInt64)
+}
+
+// toDuration converts the float back off to a duration, saturating at the largest duration
+// as converting a float64 of math.MaxInt64 or above to an int64 overflows.
+func toDuration(d float64) time.Duration {
+	if d >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// sample picks a value in [lower, upper] using Sample, or uniformly if Sample is nil
+func (b BackOff) sample(lower, upper float64) float64 {
+	if b.Sample != nil {
+		return b.Sample(lower, upper)
+	}
+	r := rand.Float64()
+	if b.Rand != nil {
+		r = b.Rand.Float64()
+	}
+	return lower + (r * (upper - lower))
 }
 
 var DefaultBackOff = BackOff{
@@ -84,12 +406,267 @@ var DefaultBackOff = BackOff{
 	Factor: 2,
 }
 
+// Decorrelated implements the decorrelated jitter back off where each sleep is a random value
+// between Min and three times the previous sleep, capped at Max.
+//
+//	sleep = min(Max, random_between(Min, prev * 3))
+//
+// Because each sleep depends on the previous sleep instead of the attempt number, Decorrelated
+// holds state and must be used as a pointer. On() and Retrier give each loop its own Clone(),
+// such that callers sharing a Policy do not share a single sequence where the sleeps of one
+// caller depend on the attempts of another. Calling Next() directly from several goroutines
+// does share one sequence; call Clone() or Reset() before using an instance for an
+// independent retry loop outside of On().
+//
+// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+type Decorrelated struct {
+	Min  time.Duration
+	Max  time.Duration
+	Rand Random
+
+	mu   sync.Mutex
+	prev time.Duration
+}
+
+func (d *Decorrelated) Next(_ int) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.prev < d.Min {
+		d.prev = d.Min
+	}
+
+	r := rand.Float64()
+	if d.Rand != nil {
+		r = d.Rand.Float64()
+	}
+
+	upper := float64(d.prev) * 3
+	next := time.Duration(float64(d.Min) + r*(upper-float64(d.Min)))
+	if next > d.Max {
+		next = d.Max
+	}
+	if next < d.Min {
+		next = d.Min
+	}
+	d.prev = next
+	return next
+}
+
+// Reset clears the previous sleep such that the next call to Next() starts the sequence over.
+func (d *Decorrelated) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prev = 0
+}
+
+// Clone returns a Decorrelated with the same configuration and no previous sleep
+func (d *Decorrelated) Clone() Interval {
+	return &Decorrelated{Min: d.Min, Max: d.Max, Rand: d.Rand}
+}
+
+// WeightedInterval is an Interval which adapts to the observed latency of the remote. Each
+// sleep is the sleep of the underlying Interval plus Weight times the latency last reported
+// via Observe(), capped at Max if Max is non zero.
+//
+//	sleep = min(Max, Interval.Next(attempt) + latency * Weight)
+//
+// Because a slow response is often the first sign a backend is struggling, this causes the
+// back off to track the recovery of the backend instead of following a fixed curve. Like
+// Decorrelated, WeightedInterval holds state and must be used as a pointer. It does not
+// implement Cloner, as the caller must Observe() the same instance the loop uses; create a
+// WeightedInterval per loop when loops sharing a Policy run concurrently, as each loop resets
+// the latency observed by the others.
+//
+//	interval := &retry.WeightedInterval{Interval: retry.DefaultBackOff, Weight: 2}
+//	err := retry.On(ctx, retry.Policy{Interval: interval}, func(ctx context.Context, _ int) error {
+//		start := time.Now()
+//		defer func() { interval.Observe(time.Since(start)) }()
+//		return client.Call(ctx)
+//	})
+type WeightedInterval struct {
+	// Interval is the base interval, if nil only the weighted latency is used
+	Interval Interval
+	// Weight is the multiple of the observed latency added to each sleep
+	Weight float64
+	// Max when non zero caps the returned sleep
+	Max time.Duration
+
+	mu      sync.Mutex
+	latency time.Duration
+}
+
+// Observe records the latency of the most recent attempt, which is used by subsequent calls
+// to Next()
+func (w *WeightedInterval) Observe(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.latency = d
+}
+
+func (w *WeightedInterval) Next(attempts int) time.Duration {
+	w.mu.Lock()
+	latency := w.latency
+	w.mu.Unlock()
+
+	var next time.Duration
+	if w.Interval != nil {
+		next = w.Interval.Next(attempts)
+	}
+	next += time.Duration(float64(latency) * w.Weight)
+	if w.Max > 0 && next > w.Max {
+		next = w.Max
+	}
+	return next
+}
+
+// Reset clears the observed latency such that Next() returns the base interval
+func (w *WeightedInterval) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.latency = 0
+}
+
+// Step is a stage of a Chain which applies Interval for the provided number of Attempts
+type Step struct {
+	Interval Interval
+	// Attempts is the number of attempts this step covers, 0 covers all remaining attempts
+	Attempts int
+}
+
+// Chain is an Interval composed of steps, where each step applies its Interval for a number
+// of attempts before handing off to the next step. The attempt passed to each step's Interval
+// is relative to the start of that step, such that a BackOff step starts from Min. The final
+// step covers all attempts beyond the end of the chain.
+//
+//	// Sleep 1 second for the first 3 attempts, then back off exponentially
+//	interval := retry.Chain{
+//		{Interval: retry.Sleep(time.Second), Attempts: 3},
+//		{Interval: retry.DefaultBackOff},
+//	}
+type Chain []Step
+
+// Clone returns a Chain where each step Interval which implements Cloner is cloned
+func (c Chain) Clone() Interval {
+	out := make(Chain, len(c))
+	for i, step := range c {
+		out[i] = step
+		out[i].Interval = cloneInterval(step.Interval)
+	}
+	return out
+}
+
+// Reset resets each step Interval which implements Resetter
+func (c Chain) Reset() {
+	for _, step := range c {
+		if r, ok := step.Interval.(Resetter); ok {
+			r.Reset()
+		}
+	}
+}
+
+func (c Chain) Next(attempt int) time.Duration {
+	var offset int
+	for i, step := range c {
+		if step.Attempts == 0 || attempt <= offset+step.Attempts || i == len(c)-1 {
+			return step.Interval.Next(attempt - offset)
+		}
+		offset += step.Attempts
+	}
+	return 0
+}
+
 type Sleep time.Duration
 
 func (s Sleep) Next(_ int) time.Duration {
 	return time.Duration(s)
 }
 
+// JitteredSleep is a constant sleep with uniform jitter applied, such that many clients
+// retrying on the same interval do not synchronize and retry in lockstep. Each sleep is a
+// random value in [Base - Jitter*Base, Base + Jitter*Base], the mean sleep remains Base.
+//
+//	// Sleep between 800ms and 1.2s between each attempt
+//	interval := retry.JitteredSleep{Base: time.Second, Jitter: 0.2}
+type JitteredSleep struct {
+	Base time.Duration
+	// Jitter is the fraction of Base by which the sleep may vary, between 0 and 1
+	Jitter float64
+	// Rand is the source of randomness used for jitter, defaults to the math/rand top level
+	// functions. Rand must be safe for concurrent use if the interval is shared; see SafeRand().
+	Rand Random
+}
+
+func (j JitteredSleep) Next(_ int) time.Duration {
+	r := rand.Float64()
+	if j.Rand != nil {
+		r = j.Rand.Float64()
+	}
+	spread := j.Jitter * float64(j.Base)
+	return time.Duration(max(float64(j.Base)-spread+(r*2*spread), 0))
+}
+
+// JitterInterval decorates an Interval with symmetric jitter, such that jitter can be added to
+// any Interval without the Interval implementing jitter itself. Each sleep is a random value in
+// [d - Jitter*d, d + Jitter*d] where d is the sleep returned by Inner.
+//
+//	// Sleep between 4s and 6s between each attempt
+//	interval := retry.JitterInterval{Inner: retry.Sleep(5 * time.Second), Jitter: 0.2}
+type JitterInterval struct {
+	Inner Interval
+	// Jitter is the fraction of the inner sleep by which the sleep may vary, between 0 and 1
+	Jitter float64
+	// Rand is the source of randomness used for jitter, defaults to the math/rand top level
+	// functions. Rand must be safe for concurrent use if the interval is shared; see SafeRand().
+	Rand Random
+}
+
+func (j JitterInterval) Next(attempt int) time.Duration {
+	d := float64(j.Inner.Next(attempt))
+	r := rand.Float64()
+	if j.Rand != nil {
+		r = j.Rand.Float64()
+	}
+	spread := j.Jitter * d
+	return toDuration(max(d-spread+(r*2*spread), 0))
+}
+
+// Reset resets the Inner interval if it implements Resetter
+func (j JitterInterval) Reset() {
+	if r, ok := j.Inner.(Resetter); ok {
+		r.Reset()
+	}
+}
+
+// Clone returns a JitterInterval with the Inner interval cloned if it implements Cloner
+func (j JitterInterval) Clone() Interval {
+	j.Inner = cloneInterval(j.Inner)
+	return j
+}
+
+// cloneInterval returns a clone of the interval if it implements Cloner, else the interval
+func cloneInterval(i Interval) Interval {
+	if c, ok := i.(Cloner); ok {
+		return c.Clone()
+	}
+	return i
+}
+
+// Clock provides the current time and sleeps for On()
+type Clock interface {
+	Now() time.Time
+	// After returns a channel which receives the current time once the duration has elapsed
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// RealClock is the default Clock which uses the time package
+var RealClock Clock = realClock{}
+
 type Policy struct {
 	// Interval is an interface which dictates how long the retry should sleep between attempts. Retry comes with
 	// two implementations called retry.BackOff which implements a backoff and retry.Sleep which is a static sleep
@@ -109,6 +686,8 @@ type Policy struct {
 	//		Attempts: 5,
 	//	}
 	//
+	// A stateful Interval which implements Cloner is cloned for each retry loop. One which does
+	// not, such as WeightedInterval, must not be shared by loops which run concurrently.
 	Interval Interval // BackOff or Sleep
 	// OnCodes is a list of service response codes that trigger retry. These are checked
 	// via HTTPCode() when the error is NOT an infrastructure error.
@@ -117,10 +696,123 @@ type Policy struct {
 	// checked via HTTPCode() when IsInfraError() returns true.
 	// A nil value means infrastructure errors are NOT retried.
 	OnInfraCodes []int
+	// OnErrors is a list of errors that trigger retry, checked via errors.Is(). An error is
+	// retried if it matches any of OnErrors, OnCodes or OnInfraCodes. If all three are nil,
+	// every error is retried, except context.Canceled and context.DeadlineExceeded which are
+	// only retried if they match OnErrors or are wrapped with Retryable().
+	OnErrors []error
+	// ShouldRetry is an optional function which decides if the error returned by the operation
+	// should be retried. The current attempt is provided such that users can retry some errors
+	// only on early attempts. When set, it takes precedence over OnErrors, OnCodes and OnInfraCodes.
+	ShouldRetry func(err error, attempt int) bool
+	// OnRetry is an optional callback invoked just before On() sleeps between attempts. It is
+	// provided the attempt which failed, the error returned and how long On() will sleep. It is
+	// never called after the final attempt or after an error that will not be retried.
+	OnRetry func(attempt int, err error, delay time.Duration)
+	// Wake is an optional channel which interrupts the current sleep when signalled, such that
+	// the next attempt is made immediately instead of waiting out the interval. Send on the
+	// channel when an external signal, such as a health check, reports the backend has
+	// recovered. Closing the channel disables every subsequent sleep between attempts. A sleep
+	// while the Budget is over is never interrupted, the budget decides when retries resume.
+	Wake <-chan struct{}
+	// Drain is an optional channel which is closed when the service begins a graceful shutdown.
+	// Once closed, no further retries are made; a loop which is sleeping between attempts
+	// returns immediately with the error from the last attempt, instead of the context error
+	// returned when the context is cancelled. An attempt which is in flight is not interrupted.
+	//
+	//	shutdown := make(chan struct{})
+	//	policy := retry.Policy{Interval: retry.DefaultBackOff, Drain: shutdown}
+	//	...
+	//	close(shutdown) // In flight retry loops return their last error
+	Drain <-chan struct{}
+	// DecorateContext is an optional function which returns the context passed to the operation
+	// for each attempt. Use it to stamp each attempt with the attempt number or start a new
+	// trace span, such that the backend and traces can distinguish retries. The returned
+	// context must be derived from the provided context.
+	DecorateContext func(ctx context.Context, attempt int) context.Context
+	// OnAttempt is an optional callback invoked after every attempt, including the first and
+	// the final attempt, with the error returned by the operation or nil on success. Unlike
+	// OnRetry, which is only called before a sleep, OnAttempt sees every outcome and so is a
+	// suitable place to report progress of a long polling loop.
+	OnAttempt func(attempt int, err error)
+	// CollectErrors when true causes On() to return all the errors returned by the operation
+	// joined via errors.Join(), each prefixed with the attempt number which returned it.
+	// When false, only the last error is returned.
+	CollectErrors bool
+	// Budget is an optional retry budget. When the budget is over, On() will not retry the
+	// operation but will continue to sleep according to the Interval until the budget
+	// recovers or the context is cancelled. The first attempt is not gated by the budget
+	// unless BudgetFirstAttempt is true.
+	Budget Budget
+	// BudgetGroup is an optional group of budgets keyed by backend. When the context passed to
+	// On() carries a key set by WithBudgetKey(), the Budget for that key is used in place of
+	// Budget. Without a key on the context, Budget is used.
+	BudgetGroup *BudgetGroup
+	// BudgetFirstAttempt when true causes On() to wait for an over budget to recover before
+	// making the first attempt. By default the first attempt is always made immediately, such
+	// that a request is never delayed when nothing has failed yet. A budget with no recorded
+	// failures is never over, so the first attempt is not delayed on a cold start either way.
+	BudgetFirstAttempt bool
+	// BudgetWaitTimeout when set limits how long On() will wait for an over budget to recover.
+	// If the budget does not recover in time, On() returns ErrBudgetExhausted, wrapped in a
+	// *BudgetExhaustedError with the last operation error if an attempt was already made.
+	BudgetWaitTimeout time.Duration
+	// BudgetOver selects whether On() waits for an over budget to recover or returns
+	// ErrBudgetExhausted immediately, defaults to BudgetBlock. With BudgetFailFast, a failed
+	// attempt which leaves the budget over ends the loop without sleeping for the next attempt.
+	BudgetOver BudgetOverBehavior
+	// BudgetWeight is an optional function which returns how much a retryable error counts
+	// against the Budget, such that a soft failure like a 429 can count as 0.3 while a 500
+	// counts as 1. It is only used when the Budget implements WeightedBudget, otherwise each
+	// failure counts as a single hit.
+	BudgetWeight func(err error) float64
+	// BudgetWeightByAttempt when true records each retryable failure against the Budget with a
+	// weight of the attempt number, such that the third failed attempt of a call counts as 3
+	// hits. Calls which have already been retried several times are more expensive, so the
+	// budget clamps down on a sustained outage sooner than when each failure counts as 1. When
+	// BudgetWeight is also set, the two weights are multiplied.
+	BudgetWeightByAttempt bool
+	// BudgetRetrySuccessOnly when true only records a success to the Budget when the operation
+	// succeeds after at least one retry. By default a success on the first attempt is also
+	// recorded, which inflates the success rate of the budget relative to the health of the
+	// retry path. Budgets which ignore successes, such as the token bucket, are unaffected.
+	BudgetRetrySuccessOnly bool
+	// RequireIdempotent when true only retries an operation which the caller marked as
+	// idempotent with WithIdempotent(), such that an operation with side effects is not
+	// repeated by accident. Any other operation makes a single attempt and returns its error.
+	RequireIdempotent bool
+	// AttemptTimeout when set limits the duration of each attempt by passing the operation a
+	// context with the provided timeout. An attempt which exceeds the timeout is retried unless
+	// ShouldRetry is set and returns false. The attempt context is cancelled once the operation
+	// returns; context.Cause() reports ErrAttemptTimeout or why the attempt ended, such as the
+	// retry loop giving up.
+	AttemptTimeout time.Duration
+	// Clock is the source of time used by On() when sleeping and recording to the Budget.
+	// Defaults to RealClock, tests may provide a fake clock to avoid waiting on the wall clock.
+	Clock Clock
+	// Breaker is an optional circuit breaker. When the breaker does not allow a call, On()
+	// returns ErrCircuitOpen instead of calling the operation, wrapped in a *CircuitOpenError
+	// with the last operation error if an attempt was already made.
+	Breaker *Breaker
+	// Limiter is an optional cap on the number of retry attempts in flight across all callers
+	// which share the Limiter. Before each retry, On() waits until the Limiter allows the
+	// attempt or the context is cancelled. The first attempt is never limited.
+	Limiter *Limiter
+	// Metrics is an optional collector of attempt, retry and outcome counters. See Metrics for
+	// the order in which the counters are called.
+	Metrics Metrics
+	// Tracer is an optional Tracer which On() and OnResult() use to start a span for the
+	// retry loop, and a child span for each attempt. A Retrier does not start spans, as it
+	// does not call the operation.
+	Tracer Tracer
 	// Attempts is the number of "attempts" before retry returns an error to the caller.
 	// Attempts includes the first attempt, it is a count of the number of "total attempts" that
 	// will be attempted.
 	Attempts int // 0 for infinite
+
+	// keepAttemptContext when true does not cancel the context of each attempt once it ends.
+	// Transport sets it, as the response body it returns outlives the attempt.
+	keepAttemptContext bool
 }
 
 // Twice policy will retry 'twice' if there was an error. Uses the default back off policy
@@ -134,15 +826,138 @@ var UntilSuccess = Policy{
 	Attempts: 0,
 }
 
-func shouldRetry(err error, policy Policy) bool {
+// permanentError marks an error as fatal, see Permanent()
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string { return p.err.Error() }
+func (p *permanentError) Unwrap() error { return p.err }
+
+// Permanent wraps the provided error such that On() stops retrying immediately regardless of
+// the policy, and returns the wrapped error to the caller. Returns nil if err is nil.
+//
+//	err := retry.On(ctx, retry.UntilSuccess, func(ctx context.Context, attempt int) error {
+//		if err := client.DoThing(ctx, &req); err != nil {
+//			if errors.Is(err, ErrInvalidConfig) {
+//				return retry.Permanent(err)
+//			}
+//			return err
+//		}
+//		return nil
+//	})
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// RetryError is returned by On() when the operation failed on every one of Policy.Attempts.
+// It is not returned when the context is cancelled, or when the error is not retryable.
+//
+//	var re *retry.RetryError
+//	if errors.As(err, &re) {
+//		log.Printf("gave up after %d attempts in %s", re.Attempts, re.Elapsed)
+//	}
+type RetryError struct {
+	// Attempts is the number of attempts made
+	Attempts int
+	// Elapsed is the time between the first attempt and the final failure
+	Elapsed time.Duration
+	// Err is the error returned by the final attempt, or all the errors if
+	// Policy.CollectErrors is true
+	Err error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("after %d attempts: %s", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// ContextError is returned by On() when the context is cancelled or exceeds its deadline after
+// at least one attempt failed, or when the next sleep would end after the context deadline. It
+// unwraps to both the context error and the last operation error, such that
+// errors.Is(err, context.Canceled) still holds while the failure which caused the retry is not lost.
+//
+//	var ce *retry.ContextError
+//	if errors.As(err, &ce) {
+//		log.Printf("gave up waiting to retry '%s'", ce.Last)
+//	}
+type ContextError struct {
+	// Err is the error returned by ctx.Err()
+	Err error
+	// Last is the error returned by the final attempt, or all the errors if
+	// Policy.CollectErrors is true
+	Last error
+}
+
+func (e *ContextError) Error() string {
+	return fmt.Sprintf("%s; last error: %s", e.Err, e.Last)
+}
+
+func (e *ContextError) Unwrap() []error {
+	return []error{e.Err, e.Last}
+}
+
+// retryableError marks an error as retryable, see Retryable()
+type retryableError struct {
+	err error
+}
+
+func (r *retryableError) Error() string { return r.err.Error() }
+func (r *retryableError) Unwrap() error { return r.err }
+
+// Retryable wraps the provided error such that On() will retry it regardless of the policy's
+// OnErrors, OnCodes, OnInfraCodes or ShouldRetry. This is useful for callers which do not use
+// duh errors, but wish to mark an error as transient. If an error is wrapped by both Permanent()
+// and Retryable(), Permanent wins and the error is not retried. Returns nil if err is nil.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err}
+}
+
+func shouldRetry(err error, policy Policy, attempt int) bool {
 	if err == nil {
 		panic("err cannot be nil")
 	}
 
-	if policy.OnCodes == nil && policy.OnInfraCodes == nil {
+	var pe *permanentError
+	if errors.As(err, &pe) {
+		return false
+	}
+
+	var re *retryableError
+	if errors.As(err, &re) {
 		return true
 	}
 
+	if policy.ShouldRetry != nil {
+		return policy.ShouldRetry(err, attempt)
+	}
+
+	for _, target := range policy.OnErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	// A context error is almost always the result of the caller's context ending, in which
+	// case another attempt can never run. Retrying would only sleep and count a failure
+	// against the budget. An attempt which exceeds Policy.AttemptTimeout is still retried.
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if policy.OnCodes == nil && policy.OnInfraCodes == nil {
+		return policy.OnErrors == nil
+	}
+
 	var hc httpCoder
 	if !errors.As(err, &hc) {
 		return false
@@ -162,9 +977,16 @@ func shouldRetry(err error, policy Policy) bool {
 	return false
 }
 
-// rateLimitDuration extracts a rate-limit sleep duration from the error's details.
-// Returns 0 if no rate-limit information is available.
+// rateLimitDuration extracts a rate-limit sleep duration from the error's RetryAfter() or
+// details. Returns 0 if no rate-limit information is available.
 func rateLimitDuration(err error) time.Duration {
+	var ra retryAfterer
+	if errors.As(err, &ra) {
+		if d := ra.RetryAfter(); d > 0 {
+			return d
+		}
+	}
+
 	var d detailer
 	if !errors.As(err, &d) {
 		return 0
@@ -186,38 +1008,238 @@ func rateLimitDuration(err error) time.Duration {
 	return 0
 }
 
+// On calls the operation until it succeeds, the policy gives up or the context is cancelled.
+// If the context has a deadline and the next sleep would end after that deadline, On returns
+// the last operation error immediately instead of sleeping for an attempt that can never run.
+// If the context is cancelled after an attempt failed, On returns a *ContextError which wraps
+// both the context error and the last operation error.
 func On(ctx context.Context, p Policy, operation func(context.Context, int) error) error {
-	attempt := 1
-	if p.Interval == nil {
-		panic("Policy.Interval cannot be nil")
+	_, err := OnResult(ctx, p, func(ctx context.Context, attempt int) (struct{}, error) {
+		return struct{}{}, operation(ctx, attempt)
+	})
+	return err
+}
+
+// RetryN calls the operation up to 'n' times, sleeping for 'sleep' between attempts, until it
+// succeeds. It returns nil on success, or the error returned by the final attempt. RetryN is
+// a convenience for scripts and tests which do not need a context or a Policy; a value of
+// 'n' less than 1 makes a single attempt.
+//
+//	err := retry.RetryN(3, time.Second, func() error {
+//		return os.Remove(path)
+//	})
+func RetryN(n int, sleep time.Duration, operation func() error) error {
+	p := Policy{Interval: Sleep(sleep), Attempts: max(n, 1)}
+	err := On(context.Background(), p, func(context.Context, int) error {
+		return operation()
+	})
+	var re *RetryError
+	if errors.As(err, &re) {
+		return re.Err
+	}
+	return err
+}
+
+// OnResult behaves like On but returns the value from the successful attempt. The zero value
+// of T is returned if no attempt succeeds.
+//
+//	resp, err := retry.OnResult(ctx, retry.Twice, func(ctx context.Context, attempt int) (*Response, error) {
+//		return client.DoThing(ctx, &req)
+//	})
+func OnResult[T any](ctx context.Context, p Policy, operation func(context.Context, int) (T, error)) (T, error) {
+	return onResult(ctx, NewRetrier(p), operation)
+}
+
+// Stats describes how a retry loop spent its time, such that callers can account for the
+// latency spent sleeping between attempts separately from the latency of the operation.
+type Stats struct {
+	// Attempts is the number of attempts made, an attempt which was blocked by the Budget,
+	// Breaker or context and never called the operation is not counted
+	Attempts int
+	// Blocked is the number of sleeps made while Policy.Budget was over, see Retrier.Blocked()
+	Blocked int
+	// TotalSleep is the total time spent sleeping between attempts, including sleeps made
+	// while waiting for Policy.Budget to recover. A sleep interrupted by the context is not
+	// counted.
+	TotalSleep time.Duration
+	// Elapsed is the time between the first attempt and the end of the loop
+	Elapsed time.Duration
+}
+
+// OnWithStats behaves like On but also returns the Stats of the retry loop
+//
+//	stats, err := retry.OnWithStats(ctx, policy, func(ctx context.Context, attempt int) error {
+//		return client.DoThing(ctx, &req)
+//	})
+//	log.Printf("slept %s of %s", stats.TotalSleep, stats.Elapsed)
+func OnWithStats(ctx context.Context, p Policy, operation func(context.Context, int) error) (Stats, error) {
+	r := NewRetrier(p)
+	_, err := onResult(ctx, r, func(ctx context.Context, attempt int) (struct{}, error) {
+		return struct{}{}, operation(ctx, attempt)
+	})
+	return r.Stats(), err
+}
+
+func onResult[T any](ctx context.Context, r *Retrier, operation func(context.Context, int) (T, error)) (T, error) {
+	var zero T
+	tracer := r.p.Tracer
+	if tracer == nil {
+		tracer = noOpTracer{}
 	}
+	ctx, end := tracer.StartSpan(ctx, SpanRetry)
 
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			err := operation(ctx, attempt)
-			if err == nil || (p.Attempts != 0 && attempt >= p.Attempts) {
-				return err
-			}
+		attempt, ok := r.Next(ctx)
+		if !ok {
+			end(r.Err())
+			return zero, r.Err()
+		}
+
+		opCtx, endAttempt := tracer.StartSpan(ctx, SpanAttempt)
+		// The attempt context is always cancelled with the reason the attempt ended, such that
+		// work started by the attempt which outlives it can find out why via context.Cause()
+		cancel := context.CancelCauseFunc(func(error) {})
+		switch {
+		case r.p.AttemptTimeout > 0:
+			opCtx, cancel = attemptContext(opCtx, attempt, r.p.AttemptTimeout)
+		case !r.p.keepAttemptContext:
+			opCtx, cancel = context.WithCancelCause(opCtx)
+		}
+		if r.p.DecorateContext != nil {
+			opCtx = r.p.DecorateContext(opCtx, attempt)
+		}
+		result, err := operation(opCtx, attempt)
+		// A deadline on the attempt context, which is not also on the parent context,
+		// means the attempt timed out.
+		timedOut := err != nil && ctx.Err() == nil && errors.Is(opCtx.Err(), context.DeadlineExceeded)
+
+		endAttempt(err)
+		r.record(err, timedOut)
+		cancel(r.attemptCause(err))
+		if err == nil {
+			end(nil)
+			return result, nil
+		}
+	}
+}
+
+// ErrAttemptTimeout is the cause of the context passed to an attempt which exceeded
+// Policy.AttemptTimeout, as returned by context.Cause()
+var ErrAttemptTimeout = errors.New("retry attempt timed out")
+
+// ErrDraining is returned when Policy.Drain is closed before the first attempt was made, such
+// as while waiting for the budget with Policy.BudgetFirstAttempt. Once an attempt was made, the
+// error from the last attempt is returned instead.
+var ErrDraining = errors.New("retry: draining")
+
+// attemptContext returns a context for an attempt which times out after 'timeout'. The
+// returned cancel records why the attempt ended, such that work started by the attempt which
+// outlives it can find out why via context.Cause() instead of a bare "context canceled".
+func attemptContext(ctx context.Context, attempt int, timeout time.Duration) (context.Context, context.CancelCauseFunc) {
+	ctx, cancelCause := context.WithCancelCause(ctx)
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout,
+		fmt.Errorf("attempt %d exceeded %s: %w", attempt, timeout, ErrAttemptTimeout))
+	return ctx, func(cause error) {
+		cancelCause(cause)
+		cancel()
+	}
+}
+
+// ErrPollPending is returned by Poll() when the attempts are exhausted or the context is cancelled
+// before the done condition is satisfied.
+var ErrPollPending = errors.New("poll condition not met")
+
+// Poll calls the operation until it returns a result for which done() returns true, the policy
+// gives up or the context is cancelled. Operation errors are retried according to the policy,
+// while a result which is not done is always retried and is counted as a failure by any
+// Budget or Breaker on the policy. If polling stops before done() is satisfied, the most
+// recent successful result is returned along with an error which wraps ErrPollPending or the
+// last operation error.
+//
+//	job, err := retry.Poll(ctx, policy, func(ctx context.Context, attempt int) (*Job, error) {
+//		return client.GetJob(ctx, &req)
+//	}, func(job *Job) bool {
+//		return job.Status != "pending"
+//	})
+func Poll[T any](ctx context.Context, p Policy, operation func(context.Context, int) (T, error),
+	done func(T) bool) (T, error) {
+	var last T
+	result, err := OnResult(ctx, p, func(ctx context.Context, attempt int) (T, error) {
+		result, err := operation(ctx, attempt)
+		if err != nil {
+			return result, err
+		}
+		last = result
+		if !done(result) {
+			return result, Retryable(ErrPollPending)
+		}
+		return result, nil
+	})
+	if err != nil {
+		return last, err
+	}
+	return result, nil
+}
 
-			if shouldRetry(err, p) {
-				sleepDur := rateLimitDuration(err)
-				if sleepDur == 0 {
-					sleepDur = p.Interval.Next(attempt)
-				}
-				timer := time.NewTimer(sleepDur)
-				select {
-				case <-ctx.Done():
-					timer.Stop()
-					return ctx.Err()
-				case <-timer.C:
-				}
-				attempt++
-			} else {
-				return err
+// ErrRetryableResponse is returned by OnResponse() when the attempts are exhausted and the last
+// response was retryable, but the operation returned no error.
+var ErrRetryableResponse = errors.New("retryable response")
+
+// OnResponse calls the operation until retryable() returns false for the response and error
+// returned, the policy gives up or the context is cancelled. Unlike OnResult, retryable()
+// decides whether an attempt is retried instead of the policy, such that a response which
+// indicates a transient condition, like a throttled status, is retried even when the error
+// is nil. An error for which retryable() returns false is returned without retrying. If the
+// loop stops before a response which is not retryable, the last response is returned along
+// with an error which wraps ErrRetryableResponse or the last operation error.
+//
+//	resp, err := retry.OnResponse(ctx, policy, func(ctx context.Context, attempt int) (*Response, error) {
+//		return client.DoThing(ctx, &req)
+//	}, func(resp *Response, err error) bool {
+//		return err != nil || resp.Status == StatusThrottled
+//	})
+func OnResponse[T any](ctx context.Context, p Policy, operation func(context.Context, int) (T, error),
+	retryable func(T, error) bool) (T, error) {
+	var last T
+	result, err := OnResult(ctx, p, func(ctx context.Context, attempt int) (T, error) {
+		result, err := operation(ctx, attempt)
+		last = result
+		if !retryable(result, err) {
+			if err != nil {
+				return result, Permanent(err)
 			}
+			return result, nil
+		}
+		if err == nil {
+			err = ErrRetryableResponse
 		}
+		return result, Retryable(err)
+	})
+	if err != nil {
+		return last, err
+	}
+	return result, nil
+}
+
+// SleepCtx blocks for the provided duration, returning ctx.Err() if the context is
+// cancelled before the duration elapses.
+func SleepCtx(ctx context.Context, d time.Duration) error {
+	_, err := sleep(ctx, RealClock, d, nil, nil)
+	return err
+}
+
+// sleep is SleepCtx() using the provided Clock, which returns early with woken set to true
+// if the wake channel is signalled, or with ErrDraining if the drain channel is closed. A nil
+// wake or drain channel is never signalled.
+func sleep(ctx context.Context, clock Clock, d time.Duration, wake, drain <-chan struct{}) (woken bool, err error) {
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-drain:
+		return false, ErrDraining
+	case <-wake:
+		return true, nil
+	case <-clock.After(d):
+		return false, nil
 	}
 }
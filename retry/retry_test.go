@@ -16,10 +16,13 @@ package retry_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strconv"
 	"sync"
 	"testing"
@@ -161,7 +164,7 @@ func TestRetry(t *testing.T) {
 				return c.DoThing(ctx, &DoThingRequest{}, &resp)
 			})
 			require.Error(t, err)
-			assert.Equal(t, context.Canceled, err)
+			assert.ErrorIs(t, err, context.Canceled)
 			wg.Done()
 		}()
 		// Cancelling
@@ -376,6 +379,825 @@ func TestBackOffConcurrentSafety(t *testing.T) {
 	wg.Wait()
 }
 
+func TestBackOffJitterMode(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		mode     retry.JitterMode
+		min, max time.Duration
+	}{
+		{name: "Symmetric", mode: retry.JitterSymmetric, min: 80 * time.Millisecond, max: 120 * time.Millisecond},
+		{name: "Full", mode: retry.JitterFull, min: 10 * time.Millisecond, max: 100 * time.Millisecond},
+		{name: "Equal", mode: retry.JitterEqual, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{name: "None", mode: retry.JitterNone, min: 100 * time.Millisecond, max: 100 * time.Millisecond},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := retry.BackOff{
+				Min:    10 * time.Millisecond,
+				Max:    time.Second,
+				Factor: 10,
+				Jitter: 0.2,
+				Mode:   tt.mode,
+			}
+			for i := 0; i < 1000; i++ {
+				d := b.Next(1)
+				assert.GreaterOrEqual(t, d, tt.min)
+				assert.LessOrEqual(t, d, tt.max)
+			}
+		})
+	}
+
+	t.Run("FullClampsToMin", func(t *testing.T) {
+		// Full jitter can pick values near zero, but must never sleep less than Min
+		b := retry.BackOff{
+			Min:    50 * time.Millisecond,
+			Max:    time.Second,
+			Factor: 2,
+			Mode:   retry.JitterFull,
+		}
+		for i := 0; i < 1000; i++ {
+			assert.GreaterOrEqual(t, b.Next(1), b.Min)
+		}
+	})
+
+	t.Run("FullCapsAtMax", func(t *testing.T) {
+		// Full jitter picks from [0, Max] once the back off exceeds Max
+		b := retry.BackOff{
+			Min:    time.Millisecond,
+			Max:    100 * time.Millisecond,
+			Factor: 2,
+			Mode:   retry.JitterFull,
+		}
+		for i := 0; i < 1000; i++ {
+			assert.LessOrEqual(t, b.Next(20), b.Max)
+		}
+	})
+}
+
+func TestBackOffSample(t *testing.T) {
+	var calls int
+	b := retry.BackOff{
+		Min:    10 * time.Millisecond,
+		Max:    time.Second,
+		Factor: 10,
+		Jitter: 0.2,
+		Mode:   retry.JitterSymmetric,
+		// Always pick the lower bound of the range
+		Sample: func(lower, upper float64) float64 {
+			calls++
+			assert.Less(t, lower, upper)
+			return lower
+		},
+	}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, 80*time.Millisecond, b.Next(1))
+	}
+	assert.Equal(t, 10, calls)
+
+	// The sample is clamped to [Min, Max]
+	b.Sample = func(lower, upper float64) float64 { return upper * 100 }
+	assert.Equal(t, time.Second, b.Next(1))
+
+	// No sample is taken when there is no jitter range
+	calls = 0
+	b.Sample = func(lower, upper float64) float64 {
+		calls++
+		return lower
+	}
+	b.Mode = retry.JitterNone
+	assert.Equal(t, 100*time.Millisecond, b.Next(1))
+	assert.Equal(t, 0, calls)
+}
+
+func TestBackOffMaxSleep(t *testing.T) {
+	// Every mode except JitterCentered never sleeps longer than Max, even with large jitter
+	for _, mode := range []retry.JitterMode{retry.JitterScaled, retry.JitterSymmetric, retry.JitterFull, retry.JitterEqual} {
+		t.Run(mode.String(), func(t *testing.T) {
+			b := retry.BackOff{
+				Min:    time.Millisecond,
+				Max:    time.Second,
+				Factor: 2,
+				Jitter: 1,
+				Mode:   mode,
+				Rand:   retry.SafeRand(1),
+			}
+			for i := 0; i < 1000; i++ {
+				assert.LessOrEqual(t, b.Next(1+i%20), b.Max)
+			}
+		})
+	}
+
+	t.Run("Centered", func(t *testing.T) {
+		b := retry.BackOff{
+			Min:      time.Millisecond,
+			Max:      time.Second,
+			Factor:   2,
+			Jitter:   1,
+			Mode:     retry.JitterCentered,
+			Rand:     retry.SafeRand(1),
+			MaxSleep: time.Second,
+		}
+		require.NoError(t, b.Validate())
+		var capped int
+		for i := 0; i < 1000; i++ {
+			d := b.Next(1 + i%20)
+			assert.LessOrEqual(t, d, time.Second)
+			if d == time.Second {
+				capped++
+			}
+		}
+		// Without MaxSleep, centered jitter would sleep up to 2s
+		assert.Greater(t, capped, 0)
+		assert.Equal(t, time.Second, b.Explain(20).RangeMax)
+	})
+
+	t.Run("CenteredDefault", func(t *testing.T) {
+		// By default Max is not the ceiling for centered jitter, which keeps the mean sleep
+		// at Max by sleeping up to Max + Jitter*Max
+		b := retry.BackOff{
+			Min:    time.Millisecond,
+			Max:    time.Second,
+			Factor: 2,
+			Jitter: 1,
+			Mode:   retry.JitterCentered,
+			Rand:   retry.SafeRand(1),
+		}
+		var above int
+		for i := 0; i < 1000; i++ {
+			d := b.Next(20)
+			assert.LessOrEqual(t, d, 2*time.Second)
+			if d > b.Max {
+				above++
+			}
+		}
+		assert.Greater(t, above, 0)
+		assert.Equal(t, 2*time.Second, b.Explain(20).RangeMax)
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		b := retry.BackOff{Min: time.Second, Max: time.Minute, Factor: 2, MaxSleep: time.Millisecond}
+		assert.EqualError(t, b.Validate(), "BackOff.MaxSleep '1ms' must not be less than BackOff.Min '1s'")
+	})
+}
+
+func TestBackOffJitterAboveOne(t *testing.T) {
+	// A Jitter above 1 fails Validate(), but a BackOff which was never validated must still
+	// never return a negative sleep
+	for _, mode := range []retry.JitterMode{retry.JitterScaled, retry.JitterSymmetric, retry.JitterCentered} {
+		t.Run(mode.String(), func(t *testing.T) {
+			b := retry.BackOff{
+				Min:    0,
+				Max:    time.Second,
+				Factor: 2,
+				Jitter: 1.5,
+				Mode:   mode,
+				Rand:   retry.SafeRand(1),
+			}
+			for i := 0; i < 1000; i++ {
+				assert.GreaterOrEqual(t, b.Next(1+i%10), time.Duration(0))
+			}
+		})
+	}
+
+	t.Run("JitteredSleep", func(t *testing.T) {
+		j := retry.JitteredSleep{Base: time.Second, Jitter: 1.5, Rand: retry.SafeRand(1)}
+		for i := 0; i < 1000; i++ {
+			assert.GreaterOrEqual(t, j.Next(i), time.Duration(0))
+		}
+	})
+}
+
+func TestBackOffJitterCentered(t *testing.T) {
+	const samples = 10000
+	b := retry.BackOff{
+		Min:    time.Millisecond,
+		Max:    time.Second,
+		Factor: 2,
+		Jitter: 0.5,
+		Mode:   retry.JitterCentered,
+	}
+
+	var sum time.Duration
+	for i := 0; i < samples; i++ {
+		d := b.Next(50)
+		assert.GreaterOrEqual(t, d, 500*time.Millisecond)
+		assert.LessOrEqual(t, d, 1500*time.Millisecond)
+		sum += d
+	}
+	// Once the back off reaches Max, the mean sleep should be close to Max
+	assert.InDelta(t, float64(time.Second), float64(sum/samples), float64(20*time.Millisecond))
+
+	// When the back off is exactly Max, symmetric jitter discards the upper half
+	// of the range, biasing the mean below Max
+	b.Min = 125 * time.Millisecond
+	b.Mode = retry.JitterSymmetric
+	sum = 0
+	for i := 0; i < samples; i++ {
+		sum += b.Next(3)
+	}
+	assert.Less(t, sum/samples, 900*time.Millisecond)
+}
+
+func TestBackOffLargeAttempts(t *testing.T) {
+	// math.Pow() overflows to +Inf for large attempts, which must not produce
+	// a negative or Min duration.
+	for _, mode := range []retry.JitterMode{
+		retry.JitterScaled,
+		retry.JitterSymmetric,
+		retry.JitterNone,
+	} {
+		b := retry.BackOff{
+			Min:    time.Millisecond,
+			Max:    time.Minute,
+			Factor: 2,
+			Jitter: 0.2,
+			Mode:   mode,
+		}
+		assert.Equal(t, time.Minute, b.Next(1000))
+		assert.Equal(t, time.Minute, b.Next(math.MaxInt))
+
+		// A high factor exceeds math.MaxInt64 within a few attempts
+		b.Factor = 1e10
+		for _, attempt := range []int{2, 3, 50} {
+			assert.Equal(t, time.Minute, b.Next(attempt))
+		}
+	}
+}
+
+func TestBackOffChart(t *testing.T) {
+	b := retry.BackOff{
+		Min:    100 * time.Millisecond,
+		Max:    time.Second,
+		Factor: 2,
+		Jitter: 0.5,
+		Mode:   retry.JitterSymmetric,
+	}
+
+	chart := b.Chart(4)
+	require.Len(t, chart, 4)
+
+	assert.Equal(t, retry.BackOffExplain{
+		Attempt:    1,
+		BackOff:    200 * time.Millisecond,
+		Clamped:    200 * time.Millisecond,
+		WithJitter: chart[0].WithJitter,
+		RangeMin:   100 * time.Millisecond,
+		RangeMax:   300 * time.Millisecond,
+	}, chart[0])
+	assert.Equal(t, 1600*time.Millisecond, chart[3].BackOff)
+	assert.Equal(t, time.Second, chart[3].Clamped)
+	assert.Equal(t, 800*time.Millisecond, chart[3].RangeMin)
+	assert.Equal(t, time.Second, chart[3].RangeMax)
+
+	for _, e := range chart {
+		assert.GreaterOrEqual(t, e.WithJitter, e.RangeMin)
+		assert.LessOrEqual(t, e.WithJitter, e.RangeMax)
+	}
+}
+
+func TestBackOffExplainClamped(t *testing.T) {
+	b := retry.DeterministicBackOff(100*time.Millisecond, time.Second, 2)
+
+	for _, attempt := range []int{4, 10, 100} {
+		e := b.Explain(attempt)
+		// The back off exceeds Max, but Next() never returns more than Max
+		assert.Greater(t, e.BackOff, b.Max)
+		assert.Equal(t, b.Max, e.Clamped)
+		assert.Equal(t, b.Next(attempt), e.Clamped)
+		assert.Equal(t, e.Clamped, e.WithJitter)
+	}
+
+	// Below Max the clamped value is the back off
+	e := b.Explain(2)
+	assert.Equal(t, 400*time.Millisecond, e.BackOff)
+	assert.Equal(t, e.BackOff, e.Clamped)
+}
+
+func TestBackOffExplainJSON(t *testing.T) {
+	b := retry.BackOff{
+		Min:    100 * time.Millisecond,
+		Max:    time.Second,
+		Factor: 2,
+		Jitter: 0.5,
+		Mode:   retry.JitterSymmetric,
+	}
+
+	e := b.Explain(1)
+	buf, err := json.Marshal(e)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(`{"attempt":1,"backoff_ns":200000000,"clamped_ns":200000000,"with_jitter_ns":%d,`+
+		`"range_min_ns":100000000,"range_max_ns":300000000}`, e.WithJitter), string(buf))
+
+	var decoded retry.BackOffExplain
+	require.NoError(t, json.Unmarshal(buf, &decoded))
+	assert.Equal(t, e, decoded)
+
+	chart := b.Chart(5)
+	buf, err = json.Marshal(chart)
+	require.NoError(t, err)
+	var decodedChart []retry.BackOffExplain
+	require.NoError(t, json.Unmarshal(buf, &decodedChart))
+	assert.Equal(t, chart, decodedChart)
+}
+
+func TestDeterministicBackOff(t *testing.T) {
+	b := retry.DeterministicBackOff(100*time.Millisecond, time.Second, 2)
+
+	var first []time.Duration
+	for i := 1; i <= 5; i++ {
+		first = append(first, b.Next(i))
+	}
+	assert.Equal(t, []time.Duration{
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}, first)
+
+	for run := 0; run < 10; run++ {
+		for i := 1; i <= 5; i++ {
+			assert.Equal(t, first[i-1], b.Next(i))
+		}
+	}
+
+	assert.Equal(t, "attempt 2: backoff 400ms, sleep 400ms (no jitter)", b.Explain(2).String())
+
+	b.Mode = retry.JitterSymmetric
+	b.Jitter = 0.5
+	e := b.Explain(1)
+	assert.Equal(t, fmt.Sprintf("attempt 1: backoff 200ms, sleep %s (jitter range 100ms - 300ms)",
+		e.WithJitter), e.String())
+}
+
+func TestBackOffSchedule(t *testing.T) {
+	b := retry.BackOff{
+		Min:    100 * time.Millisecond,
+		Max:    time.Second,
+		Factor: 2,
+	}
+
+	schedule := b.Schedule(6)
+	assert.Equal(t, []time.Duration{
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+		time.Second,
+	}, schedule)
+	for i, d := range schedule {
+		assert.Equal(t, b.Next(i+1), d)
+	}
+
+	// Jitter is never applied to the schedule
+	b.Jitter = 0.5
+	assert.Equal(t, schedule, b.Schedule(6))
+
+	b.FirstImmediate = true
+	assert.Equal(t, append([]time.Duration{0}, schedule[:5]...), b.Schedule(6))
+	assert.Empty(t, b.Schedule(0))
+}
+
+func TestBackOffFirstImmediate(t *testing.T) {
+	b := retry.DeterministicBackOff(100*time.Millisecond, time.Second, 2)
+	b.FirstImmediate = true
+	require.NoError(t, b.Validate())
+
+	var sleeps []time.Duration
+	for i := 1; i <= 5; i++ {
+		sleeps = append(sleeps, b.Next(i))
+	}
+	// The first retry is immediate, after which the sequence matches the back off without
+	// FirstImmediate shifted by one attempt
+	assert.Equal(t, []time.Duration{
+		0,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+	}, sleeps)
+	assert.Equal(t, "attempt 1: backoff 0s, sleep 0s (no jitter)", b.Explain(1).String())
+	assert.Equal(t, "attempt 2: backoff 200ms, sleep 200ms (no jitter)", b.Explain(2).String())
+
+	t.Run("ZeroMin", func(t *testing.T) {
+		// A zero Min has no base to grow from, every attempt would sleep zero
+		b := retry.DeterministicBackOff(0, time.Second, 2)
+		for i := 1; i <= 5; i++ {
+			assert.Equal(t, time.Duration(0), b.Next(i))
+		}
+		assert.EqualError(t, b.Validate(), "BackOff.Min must be greater than zero; got '0s'")
+	})
+}
+
+func TestBackOffConstantFactor(t *testing.T) {
+	b, err := retry.NewBackOff(100*time.Millisecond, time.Second, 1.0, 0)
+	require.NoError(t, err)
+
+	// A Factor of 1.0 never grows the back off beyond Min
+	for i := 1; i <= 10; i++ {
+		assert.Equal(t, 100*time.Millisecond, b.Next(i))
+	}
+	assert.Equal(t, slices.Repeat([]time.Duration{100 * time.Millisecond}, 5), b.Schedule(5))
+
+	e := b.Explain(3)
+	assert.True(t, e.Constant)
+	assert.Equal(t, "attempt 3: backoff 100ms, sleep 100ms (no jitter) (constant interval)", e.String())
+	assert.False(t, retry.DeterministicBackOff(100*time.Millisecond, time.Second, 2).Explain(3).Constant)
+}
+
+func TestNewBackOff(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		min    time.Duration
+		max    time.Duration
+		factor float64
+		jitter float64
+		err    string
+	}{
+		{
+			name: "ZeroMin",
+			min:  0, max: time.Second, factor: 2, jitter: 0.2,
+			err: "BackOff.Min must be greater than zero; got '0s'",
+		},
+		{
+			name: "NegativeMin",
+			min:  -time.Second, max: time.Second, factor: 2, jitter: 0.2,
+			err: "BackOff.Min must be greater than zero; got '-1s'",
+		},
+		{
+			name: "MaxLessThanMin",
+			min:  time.Second, max: time.Millisecond, factor: 2, jitter: 0.2,
+			err: "BackOff.Max '1ms' must not be less than BackOff.Min '1s'",
+		},
+		{
+			name: "FactorLessThanOne",
+			min:  time.Millisecond, max: time.Second, factor: 0.5, jitter: 0.2,
+			err: "BackOff.Factor must be 1.0 or higher; got '0.5'",
+		},
+		{
+			name: "NegativeJitter",
+			min:  time.Millisecond, max: time.Second, factor: 2, jitter: -0.1,
+			err: "BackOff.Jitter must be between 0 and 1; got '-0.1'",
+		},
+		{
+			name: "JitterGreaterThanOne",
+			min:  time.Millisecond, max: time.Second, factor: 2, jitter: 2.0,
+			err: "BackOff.Jitter must be between 0 and 1; got '2'",
+		},
+		{
+			name: "Valid",
+			min:  time.Millisecond, max: time.Second, factor: 2, jitter: 0.2,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := retry.NewBackOff(tt.min, tt.max, tt.factor, tt.jitter)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.min, b.Min)
+			assert.Equal(t, tt.max, b.Max)
+		})
+	}
+}
+
+func TestDecorrelated(t *testing.T) {
+	d := &retry.Decorrelated{
+		Min: 10 * time.Millisecond,
+		Max: time.Second,
+	}
+
+	t.Run("WithinRange", func(t *testing.T) {
+		for i := 1; i <= 1000; i++ {
+			n := d.Next(i)
+			assert.GreaterOrEqual(t, n, d.Min)
+			assert.LessOrEqual(t, n, d.Max)
+		}
+	})
+
+	t.Run("Decorrelates", func(t *testing.T) {
+		// Unlike BackOff, the sequence is not monotonic; successive sleeps should both
+		// rise and fall while the sequence is below Max.
+		d.Reset()
+		var up, down int
+		prev := d.Next(1)
+		for i := 2; i <= 1000; i++ {
+			n := d.Next(i)
+			if n > prev {
+				up++
+			}
+			if n < prev {
+				down++
+			}
+			prev = n
+		}
+		assert.Greater(t, up, 0)
+		assert.Greater(t, down, 0)
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		for i := 1; i <= 100; i++ {
+			d.Next(i)
+		}
+		d.Reset()
+		// After reset the first sleep is between Min and Min * 3
+		n := d.Next(1)
+		assert.GreaterOrEqual(t, n, d.Min)
+		assert.LessOrEqual(t, n, 3*d.Min)
+	})
+}
+
+type resetCounter struct {
+	resets int
+	prev   time.Duration
+}
+
+func (r *resetCounter) Next(_ int) time.Duration {
+	r.prev += time.Millisecond
+	return r.prev
+}
+
+func (r *resetCounter) Reset() {
+	r.resets++
+	r.prev = 0
+}
+
+func TestIntervalReset(t *testing.T) {
+	fail := func(ctx context.Context, attempt int) error {
+		return retry.Retryable(errors.New("fail"))
+	}
+
+	t.Run("EachCallStartsFresh", func(t *testing.T) {
+		interval := &resetCounter{}
+		clock := &fakeClock{now: time.Now()}
+		policy := retry.Policy{Interval: interval, Attempts: 3, Clock: clock}
+
+		require.Error(t, retry.On(context.Background(), policy, fail))
+		require.Error(t, retry.On(context.Background(), policy, fail))
+		assert.Equal(t, 2, interval.resets)
+		assert.Equal(t, []time.Duration{
+			time.Millisecond, 2 * time.Millisecond,
+			time.Millisecond, 2 * time.Millisecond,
+		}, clock.sleeps)
+	})
+
+	t.Run("Decorrelated", func(t *testing.T) {
+		interval := &retry.Decorrelated{Min: time.Millisecond, Max: time.Hour}
+		clock := &fakeClock{now: time.Now()}
+		policy := retry.Policy{Interval: interval, Attempts: 2, Clock: clock}
+
+		// Without a reset the first sleep of each call would grow from the previous call
+		for i := 0; i < 10; i++ {
+			require.Error(t, retry.On(context.Background(), policy, fail))
+		}
+		require.Len(t, clock.sleeps, 10)
+		for _, d := range clock.sleeps {
+			assert.LessOrEqual(t, d, 3*time.Millisecond)
+		}
+	})
+
+	t.Run("Chain", func(t *testing.T) {
+		interval := &resetCounter{}
+		policy := retry.Policy{
+			Interval: retry.Chain{{Interval: interval}},
+			Attempts: 2,
+			Clock:    &fakeClock{now: time.Now()},
+		}
+		require.Error(t, retry.On(context.Background(), policy, fail))
+		assert.Equal(t, 1, interval.resets)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		// Loops which share a Policy run concurrently, each must follow its own sequence
+		// where every sleep is at most three times the previous sleep of that loop.
+		const min = time.Microsecond
+		policy := retry.Policy{
+			Interval: retry.JitterInterval{Inner: &retry.Decorrelated{
+				Min:  min,
+				Max:  time.Millisecond,
+				Rand: retry.SafeRand(1),
+			}},
+			Attempts: 8,
+		}
+
+		var wg sync.WaitGroup
+		sleeps := make([][]time.Duration, 4)
+		for i := range sleeps {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p := policy
+				p.OnRetry = func(_ int, _ error, d time.Duration) {
+					sleeps[i] = append(sleeps[i], d)
+				}
+				_ = retry.On(context.Background(), p, fail)
+			}()
+		}
+		wg.Wait()
+
+		for _, loop := range sleeps {
+			require.Len(t, loop, 7)
+			prev := min
+			for _, d := range loop {
+				assert.LessOrEqual(t, d, 3*prev)
+				prev = d
+			}
+		}
+	})
+}
+
+func TestJitteredSleep(t *testing.T) {
+	j := retry.JitteredSleep{
+		Base:   time.Second,
+		Jitter: 0.2,
+		Rand:   retry.SafeRand(1),
+	}
+
+	const samples = 10_000
+	var sum, sumSq float64
+	lowest, highest := time.Duration(math.MaxInt64), time.Duration(0)
+	for i := 1; i <= samples; i++ {
+		n := j.Next(i)
+		lowest, highest = min(lowest, n), max(highest, n)
+		sum += float64(n)
+		sumSq += float64(n) * float64(n)
+	}
+	mean := sum / samples
+	stddev := math.Sqrt(sumSq/samples - mean*mean)
+
+	// Every sleep is within the jitter of the base
+	assert.GreaterOrEqual(t, lowest, 800*time.Millisecond)
+	assert.LessOrEqual(t, highest, 1200*time.Millisecond)
+	// The samples cover the whole range
+	assert.Less(t, lowest, 810*time.Millisecond)
+	assert.Greater(t, highest, 1190*time.Millisecond)
+	// A uniform distribution over [base - spread, base + spread] has a mean of base and
+	// a standard deviation of spread / sqrt(3)
+	assert.InEpsilon(t, float64(time.Second), mean, 0.01)
+	assert.InEpsilon(t, float64(200*time.Millisecond)/math.Sqrt(3), stddev, 0.05)
+
+	t.Run("NoJitter", func(t *testing.T) {
+		assert.Equal(t, time.Second, retry.JitteredSleep{Base: time.Second}.Next(1))
+	})
+}
+
+func TestJitterInterval(t *testing.T) {
+	j := retry.JitterInterval{
+		Inner:  retry.Sleep(time.Second),
+		Jitter: 0.2,
+		Rand:   retry.SafeRand(1),
+	}
+
+	const samples = 10_000
+	var sum float64
+	var below, above int
+	for i := 1; i <= samples; i++ {
+		n := j.Next(i)
+		assert.GreaterOrEqual(t, n, 800*time.Millisecond)
+		assert.LessOrEqual(t, n, 1200*time.Millisecond)
+		if n < time.Second {
+			below++
+		} else {
+			above++
+		}
+		sum += float64(n)
+	}
+	// The distribution is centered on the inner sleep
+	assert.InEpsilon(t, float64(time.Second), sum/samples, 0.01)
+	assert.InDelta(t, below, above, samples*0.05)
+
+	t.Run("Reset", func(t *testing.T) {
+		inner := &resetCounter{}
+		j := retry.JitterInterval{Inner: inner}
+		j.Next(1)
+		j.Next(2)
+		j.Reset()
+		assert.Equal(t, 1, inner.resets)
+		assert.Equal(t, time.Millisecond, j.Next(1))
+	})
+}
+
+func TestWeightedInterval(t *testing.T) {
+	w := &retry.WeightedInterval{
+		Interval: retry.Sleep(10 * time.Millisecond),
+		Weight:   2,
+		Max:      time.Second,
+	}
+
+	t.Run("NoObservation", func(t *testing.T) {
+		assert.Equal(t, 10*time.Millisecond, w.Next(1))
+	})
+
+	t.Run("GrowsWithLatency", func(t *testing.T) {
+		var prev time.Duration
+		for _, latency := range []time.Duration{
+			10 * time.Millisecond,
+			50 * time.Millisecond,
+			200 * time.Millisecond,
+		} {
+			w.Observe(latency)
+			n := w.Next(1)
+			assert.Equal(t, 10*time.Millisecond+2*latency, n)
+			assert.Greater(t, n, prev)
+			prev = n
+		}
+	})
+
+	t.Run("ShrinksWithLatency", func(t *testing.T) {
+		w.Observe(200 * time.Millisecond)
+		slow := w.Next(1)
+		w.Observe(20 * time.Millisecond)
+		assert.Less(t, w.Next(1), slow)
+	})
+
+	t.Run("Max", func(t *testing.T) {
+		w.Observe(time.Minute)
+		assert.Equal(t, time.Second, w.Next(1))
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		w.Observe(time.Minute)
+		w.Reset()
+		assert.Equal(t, 10*time.Millisecond, w.Next(1))
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		clock := &fakeClock{now: time.Now()}
+		interval := &retry.WeightedInterval{Weight: 1}
+		policy := retry.Policy{Interval: interval, Attempts: 4, Clock: clock}
+		_ = retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			interval.Observe(time.Duration(attempt) * 100 * time.Millisecond)
+			return retry.Retryable(errors.New("fail"))
+		})
+		assert.Equal(t, []time.Duration{
+			100 * time.Millisecond,
+			200 * time.Millisecond,
+			300 * time.Millisecond,
+		}, clock.sleeps)
+	})
+}
+
+func TestSafeRand(t *testing.T) {
+	// A BackOff with a SafeRand source must be safe to share between goroutines
+	policy := retry.Policy{
+		Interval: retry.BackOff{
+			Min:    time.Microsecond,
+			Max:    time.Millisecond,
+			Factor: 2,
+			Jitter: 0.5,
+			Rand:   retry.SafeRand(1),
+		},
+		Attempts: 5,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+				return errors.New("fail")
+			})
+		}()
+	}
+	wg.Wait()
+
+	t.Run("Deterministic", func(t *testing.T) {
+		a, b := retry.SafeRand(42), retry.SafeRand(42)
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, a.Float64(), b.Float64())
+		}
+	})
+}
+
+func TestChain(t *testing.T) {
+	chain := retry.Chain{
+		{Interval: retry.Sleep(time.Second), Attempts: 3},
+		{Interval: retry.BackOff{
+			Min:    100 * time.Millisecond,
+			Max:    time.Minute,
+			Factor: 2,
+			Mode:   retry.JitterNone,
+		}, Attempts: 2},
+		{Interval: retry.Sleep(time.Minute), Attempts: 1},
+	}
+
+	var got []time.Duration
+	for i := 1; i <= 8; i++ {
+		got = append(got, chain.Next(i))
+	}
+	assert.Equal(t, []time.Duration{
+		time.Second, time.Second, time.Second,
+		// The back off step starts at its first attempt
+		200 * time.Millisecond, 400 * time.Millisecond,
+		// The final step covers all remaining attempts
+		time.Minute, time.Minute, time.Minute,
+	}, got)
+
+	assert.Equal(t, time.Duration(0), retry.Chain{}.Next(1))
+}
+
 func TestRetrySleepContextCancel(t *testing.T) {
 	// Cancelling the context during a retry sleep must return promptly,
 	// not after the full sleep duration elapses.
@@ -405,6 +1227,1252 @@ func TestRetrySleepContextCancel(t *testing.T) {
 	assert.Less(t, elapsed, time.Second)
 }
 
+func TestSleepCtx(t *testing.T) {
+	require.NoError(t, retry.SleepCtx(context.Background(), time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := retry.SleepCtx(ctx, 5*time.Minute)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestRetrySleepContextDeadline(t *testing.T) {
+	// A sleep that would end after the context deadline should not be started,
+	// On should return the last operation error without waiting out the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	policy := retry.Policy{
+		Interval: retry.Sleep(5 * time.Second),
+		Attempts: 0,
+	}
+
+	opErr := errors.New("always fail")
+	var count int
+	start := time.Now()
+	err := retry.On(ctx, policy, func(ctx context.Context, attempt int) error {
+		count++
+		return opErr
+	})
+	elapsed := time.Since(start)
+
+	require.ErrorIs(t, err, opErr)
+	// The error shows the deadline stopped the loop
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	var ce *retry.ContextError
+	require.ErrorAs(t, err, &ce)
+	assert.Equal(t, opErr, ce.Last)
+	assert.Equal(t, 1, count)
+	assert.Less(t, elapsed, 100*time.Millisecond)
+
+	t.Run("SleepsUntilDeadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		policy := retry.Policy{
+			Interval: retry.Sleep(30 * time.Millisecond),
+			Attempts: 0,
+		}
+
+		count = 0
+		start := time.Now()
+		err := retry.On(ctx, policy, func(ctx context.Context, attempt int) error {
+			count++
+			return opErr
+		})
+		elapsed := time.Since(start)
+
+		require.ErrorIs(t, err, opErr)
+		assert.Greater(t, count, 1)
+		assert.LessOrEqual(t, elapsed, 200*time.Millisecond)
+	})
+
+	t.Run("Clock", func(t *testing.T) {
+		// The time remaining until the deadline is measured with Policy.Clock, such that a
+		// fake clock reaches the deadline without waiting on the wall clock
+		clock := &fakeClock{now: time.Now()}
+		ctx, cancel := context.WithDeadline(context.Background(), clock.now.Add(time.Hour))
+		defer cancel()
+
+		policy := retry.Policy{
+			Interval: retry.Sleep(25 * time.Minute),
+			Attempts: 10,
+			Clock:    clock,
+		}
+
+		count = 0
+		err := retry.On(ctx, policy, func(ctx context.Context, attempt int) error {
+			count++
+			return opErr
+		})
+		require.ErrorIs(t, err, opErr)
+		// The third sleep would end 15 minutes after the deadline
+		assert.Equal(t, 3, count)
+		assert.Equal(t, []time.Duration{25 * time.Minute, 25 * time.Minute}, clock.sleeps)
+	})
+}
+
+func TestOnResult(t *testing.T) {
+	ctx := context.Background()
+	policy := retry.Policy{
+		Interval: retry.Sleep(time.Millisecond),
+		Attempts: 5,
+	}
+
+	t.Run("ReturnsValue", func(t *testing.T) {
+		v, err := retry.OnResult(ctx, policy, func(ctx context.Context, attempt int) (string, error) {
+			if attempt < 3 {
+				return "partial", errors.New("not yet")
+			}
+			return "done", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "done", v)
+	})
+
+	t.Run("ZeroValueOnFailure", func(t *testing.T) {
+		var count int
+		v, err := retry.OnResult(ctx, policy, func(ctx context.Context, attempt int) (*DoThingResponse, error) {
+			count++
+			return &DoThingResponse{}, errors.New("always fail")
+		})
+		require.Error(t, err)
+		assert.Nil(t, v)
+		assert.Equal(t, 5, count)
+	})
+}
+
+func TestPoll(t *testing.T) {
+	ctx := context.Background()
+	policy := retry.Policy{
+		Interval: retry.Sleep(time.Millisecond),
+		OnCodes:  []int{duh.CodeTooManyRequests},
+		Attempts: 10,
+	}
+
+	t.Run("UntilDone", func(t *testing.T) {
+		var counter int
+		v, err := retry.Poll(ctx, policy, func(ctx context.Context, attempt int) (int, error) {
+			counter++
+			return counter, nil
+		}, func(v int) bool {
+			return v >= 5
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 5, v)
+		assert.Equal(t, 5, counter)
+	})
+
+	t.Run("RetriesErrors", func(t *testing.T) {
+		var counter int
+		v, err := retry.Poll(ctx, policy, func(ctx context.Context, attempt int) (int, error) {
+			if attempt%2 == 1 {
+				return 0, retry.Retryable(errors.New("transient"))
+			}
+			counter++
+			return counter, nil
+		}, func(v int) bool {
+			return v >= 3
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, v)
+	})
+
+	t.Run("AttemptsExhausted", func(t *testing.T) {
+		p := policy
+		p.Attempts = 3
+		v, err := retry.Poll(ctx, p, func(ctx context.Context, attempt int) (string, error) {
+			return "pending", nil
+		}, func(v string) bool {
+			return v == "ready"
+		})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, retry.ErrPollPending)
+		assert.Equal(t, "pending", v)
+
+		var retryErr *retry.RetryError
+		require.ErrorAs(t, err, &retryErr)
+		assert.Equal(t, 3, retryErr.Attempts)
+	})
+
+	t.Run("PermanentError", func(t *testing.T) {
+		var count int
+		_, err := retry.Poll(ctx, policy, func(ctx context.Context, attempt int) (int, error) {
+			count++
+			return 0, errors.New("not retryable")
+		}, func(v int) bool {
+			return true
+		})
+		require.EqualError(t, err, "not retryable")
+		assert.Equal(t, 1, count)
+	})
+}
+
+func TestOnResponse(t *testing.T) {
+	ctx := context.Background()
+	policy := retry.Policy{
+		Interval: retry.Sleep(time.Millisecond),
+		Attempts: 5,
+	}
+	type response struct {
+		status int
+	}
+	throttled := func(resp response, err error) bool {
+		return err != nil || resp.status == duh.CodeTooManyRequests
+	}
+
+	t.Run("ThrottledResponse", func(t *testing.T) {
+		var count int
+		resp, err := retry.OnResponse(ctx, policy, func(ctx context.Context, attempt int) (response, error) {
+			count++
+			// Successful but throttled responses until the third attempt
+			if attempt < 3 {
+				return response{status: duh.CodeTooManyRequests}, nil
+			}
+			return response{status: duh.CodeOK}, nil
+		}, throttled)
+		require.NoError(t, err)
+		assert.Equal(t, response{status: duh.CodeOK}, resp)
+		assert.Equal(t, 3, count)
+	})
+
+	t.Run("AttemptsExhausted", func(t *testing.T) {
+		resp, err := retry.OnResponse(ctx, policy, func(ctx context.Context, attempt int) (response, error) {
+			return response{status: duh.CodeTooManyRequests}, nil
+		}, throttled)
+		require.ErrorIs(t, err, retry.ErrRetryableResponse)
+		assert.Equal(t, response{status: duh.CodeTooManyRequests}, resp)
+
+		var retryErr *retry.RetryError
+		require.ErrorAs(t, err, &retryErr)
+		assert.Equal(t, 5, retryErr.Attempts)
+	})
+
+	t.Run("NotRetryableError", func(t *testing.T) {
+		var count int
+		_, err := retry.OnResponse(ctx, policy, func(ctx context.Context, attempt int) (response, error) {
+			count++
+			return response{}, retry.Retryable(errors.New("fatal"))
+		}, func(resp response, err error) bool {
+			return false
+		})
+		// retryable() takes precedence over the policy and a Retryable() error
+		require.EqualError(t, err, "fatal")
+		assert.Equal(t, 1, count)
+	})
+}
+
+func TestPermanent(t *testing.T) {
+	errFatal := errors.New("fatal")
+	policy := retry.Policy{
+		Interval: retry.Sleep(time.Millisecond),
+		Attempts: 5,
+	}
+
+	var count int
+	err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		count++
+		return retry.Permanent(errFatal)
+	})
+	require.Error(t, err)
+	assert.Equal(t, 1, count)
+	assert.ErrorIs(t, err, errFatal)
+	// The returned error should be the wrapped error, not the permanent wrapper
+	assert.Equal(t, errFatal, err)
+
+	t.Run("Nil", func(t *testing.T) {
+		assert.NoError(t, retry.Permanent(nil))
+	})
+}
+
+func TestRetryError(t *testing.T) {
+	errFail := errors.New("fail")
+	clock := &fakeClock{now: time.Now()}
+	policy := retry.Policy{
+		Interval: retry.Sleep(time.Second),
+		Clock:    clock,
+		Attempts: 3,
+	}
+
+	err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		return errFail
+	})
+	var re *retry.RetryError
+	require.ErrorAs(t, err, &re)
+	assert.Equal(t, 3, re.Attempts)
+	assert.Equal(t, 2*time.Second, re.Elapsed)
+	assert.ErrorIs(t, err, errFail)
+	assert.Equal(t, "after 3 attempts: fail", err.Error())
+
+	t.Run("NotRetryable", func(t *testing.T) {
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			return retry.Permanent(errFail)
+		})
+		assert.False(t, errors.As(err, &re))
+	})
+
+	t.Run("ContextCancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		err := retry.On(ctx, policy, func(ctx context.Context, attempt int) error {
+			cancel()
+			return errFail
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorIs(t, err, errFail)
+
+		var ce *retry.ContextError
+		require.True(t, errors.As(err, &ce))
+		assert.Equal(t, context.Canceled, ce.Err)
+		assert.Equal(t, errFail, ce.Last)
+		assert.Equal(t, "context canceled; last error: fail", err.Error())
+	})
+
+	t.Run("CancelledBeforeFirstAttempt", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := retry.On(ctx, policy, func(ctx context.Context, attempt int) error {
+			return errFail
+		})
+		assert.Equal(t, context.Canceled, err)
+	})
+}
+
+func TestRetryable(t *testing.T) {
+	errTransient := errors.New("transient")
+	policy := retry.Policy{
+		OnCodes:  []int{duh.CodeTooManyRequests},
+		Interval: retry.Sleep(time.Millisecond),
+		Attempts: 3,
+	}
+
+	for _, tt := range []struct {
+		name  string
+		err   error
+		count int
+	}{
+		{name: "NotMarked", err: errTransient, count: 1},
+		{name: "Retryable", err: retry.Retryable(errTransient), count: 3},
+		{name: "RetryableWrapped", err: fmt.Errorf("dial: %w", retry.Retryable(errTransient)), count: 3},
+		{name: "PermanentWinsOuter", err: retry.Permanent(retry.Retryable(errTransient)), count: 1},
+		{name: "PermanentWinsInner", err: retry.Retryable(retry.Permanent(errTransient)), count: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var count int
+			err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+				count++
+				return tt.err
+			})
+			require.ErrorIs(t, err, errTransient)
+			assert.Equal(t, tt.count, count)
+		})
+	}
+
+	assert.NoError(t, retry.Retryable(nil))
+}
+
+func TestShouldRetry(t *testing.T) {
+	errTimeout := errors.New("timeout")
+
+	t.Run("PrecedenceOverOnCodes", func(t *testing.T) {
+		policy := retry.Policy{
+			OnCodes:  []int{duh.CodeTooManyRequests},
+			Interval: retry.Sleep(time.Millisecond),
+			Attempts: 5,
+			ShouldRetry: func(err error, attempt int) bool {
+				return errors.Is(err, errTimeout)
+			},
+		}
+
+		var count int
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			count++
+			return errTimeout
+		})
+		require.ErrorIs(t, err, errTimeout)
+		assert.Equal(t, 5, count)
+	})
+
+	t.Run("ReceivesAttempt", func(t *testing.T) {
+		// Retry timeouts only on the first two attempts
+		policy := retry.Policy{
+			Interval: retry.Sleep(time.Millisecond),
+			Attempts: 5,
+			ShouldRetry: func(err error, attempt int) bool {
+				return attempt < 3
+			},
+		}
+
+		var attempts []int
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			attempts = append(attempts, attempt)
+			return errTimeout
+		})
+		require.ErrorIs(t, err, errTimeout)
+		assert.Equal(t, []int{1, 2, 3}, attempts)
+	})
+}
+
+func TestOnErrors(t *testing.T) {
+	errTransient := errors.New("transient")
+	errOther := errors.New("other")
+
+	for _, tt := range []struct {
+		name  string
+		codes []int
+		err   error
+		count int
+	}{
+		{name: "MatchesSentinel", err: errTransient, count: 3},
+		{name: "MatchesWrappedSentinel", err: fmt.Errorf("dial: %w", errTransient), count: 3},
+		{name: "NoMatch", err: errOther, count: 1},
+		{name: "MatchesCode", codes: []int{duh.CodeTooManyRequests},
+			err: &testError{code: "429", httpCode: duh.CodeTooManyRequests}, count: 3},
+		{name: "MatchesSentinelWithCodes", codes: []int{duh.CodeTooManyRequests}, err: errTransient, count: 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := retry.Policy{
+				OnErrors: []error{errTransient, context.DeadlineExceeded},
+				OnCodes:  tt.codes,
+				Interval: retry.Sleep(time.Millisecond),
+				Attempts: 3,
+			}
+
+			var count int
+			err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+				count++
+				return tt.err
+			})
+			require.Error(t, err)
+			assert.Equal(t, tt.count, count)
+		})
+	}
+}
+
+func TestContextErrorNotRetried(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		err      error
+		count    int
+		failures int
+	}{
+		{name: "DeadlineExceeded", err: context.DeadlineExceeded, count: 1},
+		{name: "Canceled", err: fmt.Errorf("while calling: %w", context.Canceled), count: 1},
+		{name: "Retryable", err: retry.Retryable(context.DeadlineExceeded), count: 3, failures: 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			budget := &countingBudget{}
+			clock := &fakeClock{now: time.Now()}
+			policy := retry.Policy{
+				Interval: retry.Sleep(time.Second),
+				Budget:   budget,
+				Clock:    clock,
+				Attempts: 3,
+			}
+
+			var count int
+			err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+				count++
+				return tt.err
+			})
+			require.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.count, count)
+			assert.Len(t, clock.sleeps, tt.count-1)
+			assert.Equal(t, tt.failures, budget.failures)
+		})
+	}
+}
+
+func TestOnRetry(t *testing.T) {
+	type call struct {
+		attempt int
+		err     error
+		delay   time.Duration
+	}
+	errFail := errors.New("fail")
+
+	t.Run("CalledBeforeEachSleep", func(t *testing.T) {
+		var calls []call
+		policy := retry.Policy{
+			Interval: retry.Sleep(time.Millisecond),
+			Attempts: 3,
+			OnRetry: func(attempt int, err error, delay time.Duration) {
+				calls = append(calls, call{attempt: attempt, err: err, delay: delay})
+			},
+		}
+
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			return errFail
+		})
+		require.ErrorIs(t, err, errFail)
+		// Not called after the final attempt
+		assert.Equal(t, []call{
+			{attempt: 1, err: errFail, delay: time.Millisecond},
+			{attempt: 2, err: errFail, delay: time.Millisecond},
+		}, calls)
+	})
+
+	t.Run("NotCalledOnNonRetryable", func(t *testing.T) {
+		var count int
+		policy := retry.Policy{
+			OnCodes:  []int{duh.CodeTooManyRequests},
+			Interval: retry.Sleep(time.Millisecond),
+			Attempts: 3,
+			OnRetry: func(attempt int, err error, delay time.Duration) {
+				count++
+			},
+		}
+
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			return &testError{code: "400", httpCode: duh.CodeBadRequest}
+		})
+		require.Error(t, err)
+		assert.Equal(t, 0, count)
+	})
+}
+
+func TestOnAttempt(t *testing.T) {
+	type call struct {
+		attempt int
+		err     error
+	}
+	errFail := errors.New("fail")
+
+	t.Run("CalledAfterEveryAttempt", func(t *testing.T) {
+		var attempts []call
+		var retries int
+		policy := retry.Policy{
+			Interval: retry.Sleep(time.Millisecond),
+			Attempts: 3,
+			OnAttempt: func(attempt int, err error) {
+				attempts = append(attempts, call{attempt: attempt, err: err})
+			},
+			OnRetry: func(attempt int, err error, delay time.Duration) {
+				retries++
+			},
+		}
+
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			return errFail
+		})
+		require.ErrorIs(t, err, errFail)
+		// Called after the final attempt, where OnRetry is not
+		assert.Equal(t, []call{
+			{attempt: 1, err: errFail},
+			{attempt: 2, err: errFail},
+			{attempt: 3, err: errFail},
+		}, attempts)
+		assert.Equal(t, 2, retries)
+	})
+
+	t.Run("CalledOnSuccess", func(t *testing.T) {
+		var attempts []call
+		policy := retry.Policy{
+			Interval: retry.Sleep(time.Millisecond),
+			OnAttempt: func(attempt int, err error) {
+				attempts = append(attempts, call{attempt: attempt, err: err})
+			},
+		}
+
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			if attempt == 1 {
+				return errFail
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []call{
+			{attempt: 1, err: errFail},
+			{attempt: 2, err: nil},
+		}, attempts)
+	})
+}
+
+func TestRetryN(t *testing.T) {
+	var count int
+	err := retry.RetryN(3, time.Millisecond, func() error {
+		count++
+		return fmt.Errorf("attempt %d", count)
+	})
+	assert.EqualError(t, err, "attempt 3")
+	assert.Equal(t, 3, count)
+
+	count = 0
+	require.NoError(t, retry.RetryN(3, time.Millisecond, func() error {
+		count++
+		if count < 2 {
+			return errors.New("fail")
+		}
+		return nil
+	}))
+	assert.Equal(t, 2, count)
+
+	count = 0
+	require.Error(t, retry.RetryN(0, time.Millisecond, func() error {
+		count++
+		return errors.New("fail")
+	}))
+	assert.Equal(t, 1, count)
+}
+
+func TestDecorateContext(t *testing.T) {
+	type attemptKey struct{}
+	policy := retry.Policy{
+		Interval:       retry.Sleep(time.Millisecond),
+		AttemptTimeout: time.Second,
+		Attempts:       3,
+		DecorateContext: func(ctx context.Context, attempt int) context.Context {
+			return context.WithValue(ctx, attemptKey{}, fmt.Sprintf("x-retry-attempt=%d", attempt))
+		},
+	}
+
+	var seen []string
+	err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		seen = append(seen, ctx.Value(attemptKey{}).(string))
+		// The decorated context is derived from the attempt context
+		_, ok := ctx.Deadline()
+		assert.True(t, ok)
+		return errors.New("fail")
+	})
+	require.Error(t, err)
+	assert.Equal(t, []string{"x-retry-attempt=1", "x-retry-attempt=2", "x-retry-attempt=3"}, seen)
+}
+
+func TestOnWithStats(t *testing.T) {
+	errFail := errors.New("fail")
+
+	t.Run("TotalSleep", func(t *testing.T) {
+		start := time.Now()
+		clock := &fakeClock{now: start}
+		policy := retry.Policy{
+			Interval: retry.DeterministicBackOff(10*time.Millisecond, time.Second, 2),
+			Attempts: 4,
+			Clock:    clock,
+		}
+		stats, err := retry.OnWithStats(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			// Each attempt does 5ms of work
+			clock.now = clock.now.Add(5 * time.Millisecond)
+			return errFail
+		})
+		require.ErrorIs(t, err, errFail)
+
+		var total time.Duration
+		for _, d := range clock.sleeps {
+			total += d
+		}
+		assert.Equal(t, 4, stats.Attempts)
+		assert.Equal(t, 140*time.Millisecond, total)
+		assert.Equal(t, total, stats.TotalSleep)
+		assert.Equal(t, total+20*time.Millisecond, stats.Elapsed)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		stats, err := retry.OnWithStats(context.Background(), retry.Policy{
+			Interval: retry.Sleep(10 * time.Millisecond),
+		}, func(ctx context.Context, attempt int) error {
+			if attempt < 3 {
+				return errFail
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, stats.Attempts)
+		assert.Equal(t, 20*time.Millisecond, stats.TotalSleep)
+		assert.GreaterOrEqual(t, stats.Elapsed, stats.TotalSleep)
+	})
+}
+
+func TestWake(t *testing.T) {
+	wake := make(chan struct{})
+	policy := retry.Policy{
+		Interval: retry.Sleep(time.Hour),
+		Attempts: 3,
+		Wake:     wake,
+	}
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			time.Sleep(20 * time.Millisecond)
+			wake <- struct{}{}
+		}
+	}()
+
+	start := time.Now()
+	stats, err := retry.OnWithStats(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		if attempt < 3 {
+			return errors.New("fail")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	// Each hour long sleep was cut short by the wake signal
+	assert.Equal(t, 3, stats.Attempts)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Greater(t, stats.TotalSleep, time.Duration(0))
+	assert.Less(t, stats.TotalSleep, time.Second)
+
+	t.Run("ClosedWithBudgetOver", func(t *testing.T) {
+		// A closed wake channel must not turn the sleeps while the budget is over into a
+		// busy loop; the budget is checked once per interval.
+		wake := make(chan struct{})
+		close(wake)
+		policy := retry.Policy{
+			Interval:          retry.Sleep(10 * time.Millisecond),
+			Budget:            &switchBudget{over: true},
+			BudgetWaitTimeout: 100 * time.Millisecond,
+			Wake:              wake,
+		}
+
+		stats, err := retry.OnWithStats(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			return errors.New("fail")
+		})
+		require.ErrorIs(t, err, retry.ErrBudgetExhausted)
+		assert.Equal(t, 1, stats.Attempts)
+		assert.LessOrEqual(t, stats.Blocked, 11)
+	})
+}
+
+func TestDrain(t *testing.T) {
+	errFail := errors.New("fail")
+
+	t.Run("MidBackOff", func(t *testing.T) {
+		drain := make(chan struct{})
+		policy := retry.Policy{
+			Interval: retry.Sleep(time.Hour),
+			Drain:    drain,
+		}
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			close(drain)
+		}()
+
+		var count int
+		start := time.Now()
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			count++
+			return errFail
+		})
+		// The hour long sleep was cut short, and the error from the operation is returned
+		// instead of a context error
+		assert.Equal(t, errFail, err)
+		assert.Equal(t, 1, count)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("AlreadyDraining", func(t *testing.T) {
+		drain := make(chan struct{})
+		close(drain)
+		policy := retry.Policy{
+			Interval: retry.Sleep(time.Millisecond),
+			Drain:    drain,
+		}
+
+		var count int
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			count++
+			return errFail
+		})
+		// The first attempt is made, but is never retried
+		assert.Equal(t, errFail, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("BudgetFirstAttempt", func(t *testing.T) {
+		drain := make(chan struct{})
+		close(drain)
+		b := retry.NewTokenBucketBudget(0, 1)
+		b.Failure(time.Now(), 1)
+		policy := retry.Policy{
+			Interval:           retry.Sleep(time.Millisecond),
+			Budget:             b,
+			BudgetFirstAttempt: true,
+			Drain:              drain,
+		}
+
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			t.Fatal("operation should not be called")
+			return nil
+		})
+		assert.ErrorIs(t, err, retry.ErrDraining)
+	})
+}
+
+func TestCollectErrors(t *testing.T) {
+	errOne := errors.New("one")
+	errTwo := errors.New("two")
+	errThree := errors.New("three")
+
+	policy := retry.Policy{
+		Interval:      retry.Sleep(time.Millisecond),
+		Attempts:      3,
+		CollectErrors: true,
+	}
+
+	err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		return []error{errOne, errTwo, errThree}[attempt-1]
+	})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errOne)
+	assert.ErrorIs(t, err, errTwo)
+	assert.ErrorIs(t, err, errThree)
+	assert.Equal(t, "after 3 attempts: attempt 1: one\nattempt 2: two\nattempt 3: three", err.Error())
+}
+
+func TestWithIdempotent(t *testing.T) {
+	errFail := errors.New("fail")
+	for _, tt := range []struct {
+		name     string
+		require  bool
+		ctx      context.Context
+		attempts int
+	}{
+		{name: "Unmarked", ctx: context.Background(), attempts: 3},
+		{name: "Idempotent", ctx: retry.WithIdempotent(context.Background(), true), attempts: 3},
+		{name: "NotIdempotent", ctx: retry.WithIdempotent(context.Background(), false), attempts: 1},
+		{name: "RequireUnmarked", require: true, ctx: context.Background(), attempts: 1},
+		{name: "RequireIdempotent", require: true, ctx: retry.WithIdempotent(context.Background(), true), attempts: 3},
+		{name: "RequireNotIdempotent", require: true, ctx: retry.WithIdempotent(context.Background(), false), attempts: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			budget := &countingBudget{}
+			policy := retry.Policy{
+				Interval:          retry.Sleep(time.Millisecond),
+				Budget:            budget,
+				RequireIdempotent: tt.require,
+				Attempts:          3,
+			}
+
+			var count int
+			err := retry.On(tt.ctx, policy, func(ctx context.Context, attempt int) error {
+				count++
+				return retry.Retryable(errFail)
+			})
+			require.ErrorIs(t, err, errFail)
+			assert.Equal(t, tt.attempts, count)
+
+			var retryErr *retry.RetryError
+			if tt.attempts == 1 {
+				// The error from the first attempt is returned, and as it was never going
+				// to be retried it does not consume the budget
+				assert.False(t, errors.As(err, &retryErr))
+				assert.Equal(t, 0, budget.failures)
+				return
+			}
+			require.ErrorAs(t, err, &retryErr)
+			assert.Equal(t, 3, budget.failures)
+		})
+	}
+}
+
+func TestWithMaxAttempts(t *testing.T) {
+	policy := retry.Policy{
+		Interval: retry.Sleep(time.Millisecond),
+		Attempts: 5,
+	}
+
+	t.Run("OverridesPolicy", func(t *testing.T) {
+		var count int
+		ctx := retry.WithMaxAttempts(context.Background(), 1)
+		err := retry.On(ctx, policy, func(ctx context.Context, attempt int) error {
+			count++
+			return errors.New("fail")
+		})
+		require.Error(t, err)
+		assert.Equal(t, 1, count)
+
+		var retryErr *retry.RetryError
+		require.ErrorAs(t, err, &retryErr)
+		assert.Equal(t, 1, retryErr.Attempts)
+	})
+
+	t.Run("ZeroMeansInfinite", func(t *testing.T) {
+		var count int
+		ctx := retry.WithMaxAttempts(context.Background(), 0)
+		err := retry.On(ctx, policy, func(ctx context.Context, attempt int) error {
+			count++
+			if attempt < 10 {
+				return errors.New("fail")
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 10, count)
+	})
+
+	t.Run("PolicyWithoutOverride", func(t *testing.T) {
+		var count int
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			count++
+			return errors.New("fail")
+		})
+		require.Error(t, err)
+		assert.Equal(t, 5, count)
+	})
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	// OnCodes would normally prevent a non duh error from being retried,
+	// but an attempt timeout is retried by default.
+	policy := retry.Policy{
+		OnCodes:        []int{duh.CodeTooManyRequests},
+		Interval:       retry.Sleep(time.Millisecond),
+		AttemptTimeout: 10 * time.Millisecond,
+		Attempts:       3,
+	}
+
+	var count int
+	start := time.Now()
+	err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		count++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 3, count)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestAttemptTimeoutCause(t *testing.T) {
+	errFail := errors.New("fail")
+	policy := retry.Policy{
+		Interval:       retry.Sleep(time.Millisecond),
+		AttemptTimeout: 10 * time.Millisecond,
+		Attempts:       2,
+	}
+
+	var causes []string
+	var contexts []context.Context
+	err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		contexts = append(contexts, ctx)
+		if attempt == 1 {
+			<-ctx.Done()
+			causes = append(causes, context.Cause(ctx).Error())
+			assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+			assert.ErrorIs(t, context.Cause(ctx), retry.ErrAttemptTimeout)
+			return ctx.Err()
+		}
+		return errFail
+	})
+	require.ErrorIs(t, err, errFail)
+	assert.Equal(t, []string{"attempt 1 exceeded 10ms: retry attempt timed out"}, causes)
+
+	// Work started by the final attempt which outlives it can find out why it was cancelled
+	require.Len(t, contexts, 2)
+	assert.ErrorIs(t, contexts[1].Err(), context.Canceled)
+	assert.EqualError(t, context.Cause(contexts[1]), "retry: gave up: after 2 attempts: fail")
+
+	t.Run("Succeeded", func(t *testing.T) {
+		var attemptCtx context.Context
+		require.NoError(t, retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			attemptCtx = ctx
+			return nil
+		}))
+		assert.ErrorIs(t, attemptCtx.Err(), context.Canceled)
+		assert.EqualError(t, context.Cause(attemptCtx), "retry: attempt 1 succeeded")
+	})
+}
+
+func TestAttemptCause(t *testing.T) {
+	errFail := errors.New("fail")
+
+	t.Run("AttemptsExhausted", func(t *testing.T) {
+		// The cause is recorded without Policy.AttemptTimeout
+		policy := retry.Policy{Interval: retry.Sleep(time.Millisecond), Attempts: 2}
+		var contexts []context.Context
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			contexts = append(contexts, ctx)
+			return errFail
+		})
+		require.ErrorIs(t, err, errFail)
+		require.Len(t, contexts, 2)
+		assert.EqualError(t, context.Cause(contexts[0]), "retry: attempt 1 failed: fail")
+		assert.EqualError(t, context.Cause(contexts[1]), "retry: gave up: after 2 attempts: fail")
+	})
+
+	t.Run("BudgetFailFast", func(t *testing.T) {
+		policy := retry.Policy{
+			Interval:   retry.Sleep(time.Millisecond),
+			Budget:     retry.NewTokenBucketBudget(0, 1),
+			BudgetOver: retry.BudgetFailFast,
+		}
+		var attemptCtx context.Context
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			attemptCtx = ctx
+			return errFail
+		})
+		require.ErrorIs(t, err, retry.ErrBudgetExhausted)
+		require.ErrorIs(t, err, errFail)
+		var be *retry.BudgetExhaustedError
+		require.ErrorAs(t, err, &be)
+		assert.Equal(t, errFail, be.Last)
+		assert.EqualError(t, context.Cause(attemptCtx), "retry: gave up: retry budget exhausted; last error: fail")
+	})
+
+	t.Run("BudgetWaitTimeout", func(t *testing.T) {
+		// The budget is only found to be over after the retry sleep, the returned error
+		// still carries the last operation error
+		policy := retry.Policy{
+			Interval:          retry.Sleep(time.Second),
+			Budget:            retry.NewTokenBucketBudget(0, 1),
+			BudgetWaitTimeout: 2 * time.Second,
+			Clock:             &fakeClock{now: time.Now()},
+		}
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			return errFail
+		})
+		assert.EqualError(t, err, "retry budget exhausted; last error: fail")
+	})
+}
+
+// fakeClock advances time by the requested duration instead of sleeping
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("BackOff", func(t *testing.T) {
+		clock := &fakeClock{now: start}
+		policy := retry.Policy{
+			Interval: retry.BackOff{
+				Min:    time.Second,
+				Max:    time.Minute,
+				Factor: 2,
+				Mode:   retry.JitterNone,
+			},
+			Clock:    clock,
+			Attempts: 4,
+		}
+
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			return errors.New("fail")
+		})
+		require.Error(t, err)
+		assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second}, clock.sleeps)
+	})
+
+	t.Run("Budget", func(t *testing.T) {
+		// The budget refills one token every 10 seconds, so the retry is blocked
+		// until 12 seconds of fake time have elapsed.
+		clock := &fakeClock{now: start}
+		policy := retry.Policy{
+			Interval: retry.Sleep(4 * time.Second),
+			Budget:   retry.NewTokenBucketBudget(0.1, 1),
+			Clock:    clock,
+			Attempts: 2,
+		}
+
+		var count int
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			count++
+			return errors.New("fail")
+		})
+		require.Error(t, err)
+		assert.Equal(t, 2, count)
+		assert.Equal(t, []time.Duration{4 * time.Second, 4 * time.Second, 4 * time.Second}, clock.sleeps)
+		assert.Equal(t, start.Add(12*time.Second), clock.Now())
+	})
+}
+
+type countingMetrics struct {
+	attempts, retries, blocked int
+	outcomes                   []bool
+}
+
+func (m *countingMetrics) IncAttempt()                 { m.attempts++ }
+func (m *countingMetrics) IncRetry()                   { m.retries++ }
+func (m *countingMetrics) IncBudgetBlocked()           { m.blocked++ }
+func (m *countingMetrics) ObserveOutcome(success bool) { m.outcomes = append(m.outcomes, success) }
+
+func TestMetrics(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		m := &countingMetrics{}
+		policy := retry.Policy{
+			Interval: retry.Sleep(time.Millisecond),
+			Metrics:  m,
+			Attempts: 5,
+		}
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			if attempt < 3 {
+				return errors.New("fail")
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, m.attempts)
+		assert.Equal(t, 2, m.retries)
+		assert.Equal(t, 0, m.blocked)
+		assert.Equal(t, []bool{true}, m.outcomes)
+	})
+
+	t.Run("BudgetBlocked", func(t *testing.T) {
+		m := &countingMetrics{}
+		policy := retry.Policy{
+			Interval: retry.Sleep(4 * time.Second),
+			Budget:   retry.NewTokenBucketBudget(0.1, 1),
+			Clock:    &fakeClock{now: time.Now()},
+			Metrics:  m,
+			Attempts: 2,
+		}
+		err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+			return errors.New("fail")
+		})
+		require.Error(t, err)
+		assert.Equal(t, 2, m.attempts)
+		assert.Equal(t, 1, m.retries)
+		assert.Equal(t, 2, m.blocked)
+		assert.Equal(t, []bool{false}, m.outcomes)
+	})
+}
+
+// span is a span recorded by fakeTracer
+type span struct {
+	name   string
+	parent string
+	err    error
+	ended  bool
+}
+
+type spanKey struct{}
+
+// fakeTracer records the spans started, and the name of each span's parent
+type fakeTracer struct {
+	mu    sync.Mutex
+	spans []*span
+}
+
+func (f *fakeTracer) StartSpan(ctx context.Context, name string) (context.Context, func(error)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := &span{name: name}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		s.parent = parent.name
+	}
+	f.spans = append(f.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), func(err error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		s.err, s.ended = err, true
+	}
+}
+
+func TestTracer(t *testing.T) {
+	errFail := errors.New("fail")
+	tracer := &fakeTracer{}
+	policy := retry.Policy{
+		Interval: retry.Sleep(time.Millisecond),
+		Tracer:   tracer,
+		Attempts: 5,
+	}
+
+	err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		// The operation context carries the attempt span
+		s := ctx.Value(spanKey{}).(*span)
+		assert.Equal(t, retry.SpanAttempt, s.name)
+		if attempt < 3 {
+			return retry.Retryable(errFail)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// A single retry span with a child span for each attempt
+	require.Len(t, tracer.spans, 4)
+	assert.Equal(t, &span{name: retry.SpanRetry, ended: true}, tracer.spans[0])
+	for i, s := range tracer.spans[1:] {
+		assert.Equal(t, retry.SpanAttempt, s.name)
+		assert.Equal(t, retry.SpanRetry, s.parent)
+		assert.True(t, s.ended)
+		if i < 2 {
+			assert.ErrorIs(t, s.err, errFail)
+		} else {
+			assert.NoError(t, s.err)
+		}
+	}
+
+	t.Run("Exhausted", func(t *testing.T) {
+		tracer := &fakeTracer{}
+		p := policy
+		p.Tracer = tracer
+		p.Attempts = 2
+		err := retry.On(context.Background(), p, func(ctx context.Context, attempt int) error {
+			return retry.Retryable(errFail)
+		})
+		require.Error(t, err)
+		require.Len(t, tracer.spans, 3)
+		// The retry span ends with the error returned to the caller
+		assert.Equal(t, err, tracer.spans[0].err)
+	})
+}
+
+type retryAfterError struct {
+	after time.Duration
+}
+
+func (e retryAfterError) Error() string             { return "retry after" }
+func (e retryAfterError) RetryAfter() time.Duration { return e.after }
+
+func TestRetryAfterHint(t *testing.T) {
+	// A server provided hint replaces the back off, which would otherwise sleep 100ms
+	policy := retry.Policy{
+		Interval: retry.BackOff{
+			Min:    100 * time.Millisecond,
+			Max:    time.Second,
+			Factor: 1,
+			Mode:   retry.JitterNone,
+		},
+		Attempts: 3,
+	}
+
+	for _, tt := range []struct {
+		name  string
+		err   error
+		sleep time.Duration
+	}{
+		{name: "RetryAfter", err: retryAfterError{after: 3 * time.Second}, sleep: 3 * time.Second},
+		{name: "InfraHeader", err: makeInfraErrorWithHeader(t, http.StatusServiceUnavailable, "3"),
+			sleep: 3 * time.Second},
+		{name: "NoHint", err: errors.New("fail"), sleep: 100 * time.Millisecond},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Now()}
+			policy.Clock = clock
+			err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+				return tt.err
+			})
+			require.Error(t, err)
+			assert.Equal(t, []time.Duration{tt.sleep, tt.sleep}, clock.sleeps)
+		})
+	}
+}
+
+// makeInfraErrorWithHeader creates an infra error from a response which includes a Retry-After header
+func makeInfraErrorWithHeader(t *testing.T, statusCode int, retryAfter string) error {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/test", nil)
+	resp := &http.Response{
+		StatusCode: statusCode,
+		Status:     strconv.Itoa(statusCode) + " " + http.StatusText(statusCode),
+		Header:     http.Header{"Retry-After": []string{retryAfter}},
+	}
+	return duh.NewInfraError(req, resp, []byte("infra error body"))
+}
+
 // makeInfraError creates a *duh.ClientError with IsInfraError() == true by using duh.NewInfraError
 // with a test HTTP response.
 func makeInfraError(t *testing.T, statusCode int) error {
@@ -0,0 +1,87 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package retrytest provides helpers for testing code which uses retry policies, such that
+// a test can drive a retry.Budget into a known state and control time without sleeping.
+package retrytest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/duh-rpc/duh.go/v2/retry"
+)
+
+// DrainBudget records the provided number of failures and successes to the budget at 'now'.
+// Use it to drive a budget into a known state before exercising a retry policy.
+//
+//	budget := retry.NewEWMABudget(0.1, time.Minute)
+//	retrytest.DrainBudget(budget, clock.Now(), 5, 10)
+//	assert.True(t, budget.IsOver(clock.Now()))
+func DrainBudget(b retry.Budget, now time.Time, failures, successes int) {
+	if failures > 0 {
+		b.Failure(now, failures)
+	}
+	if successes > 0 {
+		b.Success(now, successes)
+	}
+}
+
+// Clock is a retry.Clock which only moves when advanced. Sleeps made by retry.On() return
+// immediately and advance the clock by the requested duration, such that a retry loop
+// completes instantly while the budget and breaker observe the passage of time.
+//
+//	clock := retrytest.NewClock(time.Now())
+//	policy := retry.Policy{Interval: retry.DefaultBackOff, Clock: clock}
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+// NewClock returns a Clock which starts at 'now'
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// Advance moves the clock forward by 'd' and returns the new time
+func (c *Clock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// Sleeps returns the durations of every sleep requested via After() in order
+func (c *Clock) Sleeps() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.sleeps...)
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retrytest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/duh-rpc/duh.go/v2/retry/retrytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainBudget(t *testing.T) {
+	clock := retrytest.NewClock(time.Now())
+	budget := retry.NewEWMABudget(0.5, time.Minute)
+
+	retrytest.DrainBudget(budget, clock.Now(), 5, 10)
+	assert.False(t, budget.IsOver(clock.Now()))
+
+	retrytest.DrainBudget(budget, clock.Now(), 1, 0)
+	assert.True(t, budget.IsOver(clock.Now()))
+
+	// Failures decay while successes recorded later keep their full weight
+	retrytest.DrainBudget(budget, clock.Advance(10*time.Minute), 0, 1)
+	assert.False(t, budget.IsOver(clock.Now()))
+}
+
+func TestClock(t *testing.T) {
+	start := time.Now()
+	clock := retrytest.NewClock(start)
+	assert.Equal(t, start.Add(time.Second), clock.Advance(time.Second))
+
+	policy := retry.Policy{
+		Interval: retry.Sleep(time.Minute),
+		Attempts: 3,
+		Clock:    clock,
+	}
+	err := retry.On(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		return retry.Retryable(errors.New("fail"))
+	})
+	assert.Error(t, err)
+	assert.Equal(t, []time.Duration{time.Minute, time.Minute}, clock.Sleeps())
+	assert.Equal(t, start.Add(time.Second+2*time.Minute), clock.Now())
+}
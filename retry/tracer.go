@@ -0,0 +1,56 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import "context"
+
+const (
+	// SpanRetry is the name of the span which covers the entire retry loop
+	SpanRetry = "retry"
+	// SpanAttempt is the name of the span which covers each attempt of the operation, it is
+	// a child of the SpanRetry span
+	SpanAttempt = "retry.attempt"
+)
+
+// Tracer creates spans for On(), OnResult() and the functions built upon them, such that
+// tracing can be integrated without this package depending on a tracing library.
+// Implementations must be safe for concurrent use when the Policy is shared across goroutines.
+//
+// StartSpan starts a span named 'name' as a child of any span in ctx, and returns a context
+// which carries the new span along with a function which ends the span with the error the
+// span ended with, or nil on success. A single SpanRetry span is started for each call, and
+// a SpanAttempt span is started for each attempt of the operation.
+//
+//	type otelTracer struct{ tracer trace.Tracer }
+//
+//	func (t otelTracer) StartSpan(ctx context.Context, name string) (context.Context, func(error)) {
+//		ctx, span := t.tracer.Start(ctx, name)
+//		return ctx, func(err error) {
+//			if err != nil {
+//				span.RecordError(err)
+//				span.SetStatus(codes.Error, err.Error())
+//			}
+//			span.End()
+//		}
+//	}
+type Tracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, func(err error))
+}
+
+type noOpTracer struct{}
+
+func (noOpTracer) StartSpan(ctx context.Context, _ string) (context.Context, func(error)) {
+	return ctx, func(error) {}
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// StatusError is the error On() is given by Transport for a response with a status code of
+// 400 or higher, such that the Policy can decide if the response should be retried.
+type StatusError struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// Status is the HTTP status line of the response, e.g. "503 Service Unavailable"
+	Status string
+	// Infra is true if the response did not originate from a DUH service, see Transport
+	Infra bool
+	// Wait is the duration requested by the Retry-After header, if any
+	Wait time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP status %s", e.Status)
+}
+
+func (e *StatusError) HTTPCode() int {
+	return e.StatusCode
+}
+
+func (e *StatusError) IsInfraError() bool {
+	return e.Infra
+}
+
+func (e *StatusError) RetryAfter() time.Duration {
+	return e.Wait
+}
+
+// Transport is an http.RoundTripper which retries requests according to the Policy. Each
+// response with a status of 400 or higher is given to the Policy as a *StatusError, so a
+// policy which retries duh.RetryableCodes and duh.RetryableInfraCodes behaves like retrying
+// a duh.Client call. A response is classified as infrastructure using the same rules as the
+// duh client; a 5xx or 404 which does not carry a DUH reply did not originate from the service.
+//
+//	client := &http.Client{
+//		Transport: &retry.Transport{
+//			Policy:       duh.PolicyOnRetryable(),
+//			RetryMethods: []string{http.MethodPost},
+//		},
+//	}
+//
+// Only idempotent methods, requests with an Idempotency-Key or X-Idempotency-Key header and
+// methods listed in RetryMethods are retried. A request with a body is only retried if the
+// body can be rewound via Request.GetBody, which http.NewRequest sets for common body types.
+// If the Policy gives up on a response, that response is returned without an error as
+// required of a RoundTripper. Each attempt is sent with the context On() provides for the
+// attempt, such that Policy.DecorateContext and Policy.Tracer reach the outgoing request.
+// Policy.AttemptTimeout is not applied, as the response body outlives the attempt.
+type Transport struct {
+	// Base is the RoundTripper used to make each attempt, defaults to http.DefaultTransport
+	Base http.RoundTripper
+	// Policy decides which responses and errors are retried
+	Policy Policy
+	// RetryMethods are methods which are not idempotent but are safe to retry, such as
+	// http.MethodPost for DUH RPC calls which the caller knows are safe to repeat.
+	RetryMethods []string
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	rewindable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if !t.retryable(req) || !rewindable {
+		return base.RoundTrip(req)
+	}
+
+	// On() cancels the attempt context when the attempt ends, which would close the body of
+	// the response returned to the caller.
+	p := t.Policy
+	p.AttemptTimeout = 0
+	p.keepAttemptContext = true
+
+	var last *http.Response
+	err := On(req.Context(), p, func(ctx context.Context, attempt int) error {
+		r := req.Clone(ctx)
+		if attempt > 1 {
+			drain(last)
+			last = nil
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return Permanent(fmt.Errorf("while rewinding request body: %w", err))
+				}
+				r.Body = body
+			}
+		}
+		resp, err := base.RoundTrip(r)
+		if err != nil {
+			return err
+		}
+		last = resp
+		if resp.StatusCode < http.StatusBadRequest {
+			return nil
+		}
+		return newStatusError(resp)
+	})
+
+	var se *StatusError
+	if err == nil || (last != nil && req.Context().Err() == nil && errors.As(err, &se)) {
+		return last, nil
+	}
+	drain(last)
+	return nil, err
+}
+
+// retryable returns true if the request method may be repeated
+func (t *Transport) retryable(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	if req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != "" {
+		return true
+	}
+	return slices.Contains(t.RetryMethods, req.Method)
+}
+
+func newStatusError(resp *http.Response) *StatusError {
+	se := &StatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		se.Wait = time.Duration(seconds) * time.Second
+	}
+	// A DUH service always replies with a reply body in a known Content-Type or with the
+	// X-DUH-Version header, anything else came from the infrastructure in between.
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	reply := mediaType == "application/json" || mediaType == "application/protobuf" ||
+		resp.Header.Get("X-DUH-Version") != ""
+	if !reply && (resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusNotFound) {
+		se.Infra = true
+	}
+	return se
+}
+
+// drain discards the remaining body of a response which will not be returned to the caller,
+// such that the underlying connection can be reused.
+func drain(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+}
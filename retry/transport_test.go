@@ -0,0 +1,167 @@
+/*
+Copyright 2023 Derrick J Wippler
+
+Licensed under the MIT License, you may obtain a copy of the License at
+
+https://opensource.org/license/mit/ or in the root of this code repo
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	duh "github.com/duh-rpc/duh.go/v2"
+	"github.com/duh-rpc/duh.go/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type attemptKey struct{}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// failingServer returns a server which responds with 'code' for the first 'failures'
+// requests and echos the request body for every request after
+func failingServer(t *testing.T, failures int32, code int) (*httptest.Server, *atomic.Int32) {
+	var count atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if count.Add(1) <= failures {
+			w.WriteHeader(code)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &count
+}
+
+func TestTransport(t *testing.T) {
+	policy := duh.PolicyOnRetryable()
+	policy.Interval = retry.Sleep(time.Millisecond)
+	policy.Attempts = 5
+
+	t.Run("Recovers", func(t *testing.T) {
+		server, count := failingServer(t, 3, http.StatusServiceUnavailable)
+		client := &http.Client{Transport: &retry.Transport{Policy: policy}}
+
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int32(4), count.Load())
+	})
+
+	t.Run("RewindsBody", func(t *testing.T) {
+		server, count := failingServer(t, 2, http.StatusBadGateway)
+		client := &http.Client{Transport: &retry.Transport{
+			Policy:       policy,
+			RetryMethods: []string{http.MethodPost},
+		}}
+
+		resp, err := client.Post(server.URL, "text/plain", strings.NewReader("hello"))
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+		assert.Equal(t, int32(3), count.Load())
+	})
+
+	t.Run("NonIdempotentNotRetried", func(t *testing.T) {
+		server, count := failingServer(t, 2, http.StatusServiceUnavailable)
+		client := &http.Client{Transport: &retry.Transport{Policy: policy}}
+
+		resp, err := client.Post(server.URL, "text/plain", strings.NewReader("hello"))
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, int32(1), count.Load())
+	})
+
+	t.Run("IdempotencyKey", func(t *testing.T) {
+		server, count := failingServer(t, 1, http.StatusServiceUnavailable)
+		client := &http.Client{Transport: &retry.Transport{Policy: policy}}
+
+		req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("hello"))
+		require.NoError(t, err)
+		req.Header.Set("Idempotency-Key", "abc")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int32(2), count.Load())
+	})
+
+	t.Run("GivesUp", func(t *testing.T) {
+		server, count := failingServer(t, 10, http.StatusServiceUnavailable)
+		client := &http.Client{Transport: &retry.Transport{Policy: policy}}
+
+		// The final response is returned without an error
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, int32(5), count.Load())
+	})
+
+	t.Run("AttemptContext", func(t *testing.T) {
+		server, _ := failingServer(t, 2, http.StatusServiceUnavailable)
+		p := policy
+		p.AttemptTimeout = time.Minute
+		p.DecorateContext = func(ctx context.Context, attempt int) context.Context {
+			return context.WithValue(ctx, attemptKey{}, attempt)
+		}
+		var attempts []any
+		var last context.Context
+		client := &http.Client{Transport: &retry.Transport{
+			Policy: p,
+			Base: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				last = r.Context()
+				attempts = append(attempts, r.Context().Value(attemptKey{}))
+				return http.DefaultTransport.RoundTrip(r)
+			}),
+		}}
+
+		// Each attempt is sent with the context decorated for that attempt
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		assert.Equal(t, []any{1, 2, 3}, attempts)
+
+		// The response body is still readable after the attempt has ended
+		require.NoError(t, last.Err())
+		_, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+	})
+
+	t.Run("NotRetryableCode", func(t *testing.T) {
+		server, count := failingServer(t, 2, http.StatusBadRequest)
+		client := &http.Client{Transport: &retry.Transport{Policy: policy}}
+
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Equal(t, int32(1), count.Load())
+	})
+}